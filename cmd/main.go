@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/kubackup/kubackup"
 	"github.com/kubackup/kubackup/internal/cmdServer"
+	"github.com/kubackup/kubackup/internal/entity/v1/config"
 	"github.com/kubackup/kubackup/internal/route"
 	"github.com/kubackup/kubackup/internal/server"
 	"github.com/spf13/cobra"
@@ -16,6 +17,8 @@ var (
 	configPath     string
 	serverBindHost string
 	serverBindPort int
+	newKey         config.EncryptConfig
+	plainKey       bool
 )
 
 //go:embed web/dashboard
@@ -72,12 +75,32 @@ var resetPwdCmd = &cobra.Command{
 	},
 }
 
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "更换存储库凭据的主密钥",
+	Long:  `使用当前配置的主密钥解密数据库中的存储库凭据，并以新主密钥重新加密`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if newKey.Key == "" && newKey.KeyFile == "" && !plainKey {
+			fmt.Println("请指定新主密钥 --new-key 或 --new-key-file")
+			return
+		}
+		fmt.Println("正在更换主密钥")
+		cmdServer.Instance(configPath).Rekey(newKey, plainKey, 0)
+	},
+}
+
 func init() {
 	rootCmd.Flags().StringVar(&serverBindHost, "server-bind-host", "", "bind address")
 	rootCmd.Flags().IntVarP(&serverBindPort, "server-bind-port", "p", 0, "bind port")
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config-path", "c", "", "config file path")
 	rootCmd.AddCommand(resetOtpCmd)
 	rootCmd.AddCommand(resetPwdCmd)
+	rekeyCmd.Flags().StringVar(&newKey.Key, "new-key", "", "new master key")
+	rekeyCmd.Flags().StringVar(&newKey.KeyFile, "new-key-file", "", "file containing the new master key")
+	rekeyCmd.Flags().StringVar(&newKey.Algorithm, "new-algorithm", "", "aes-gcm or sm4-gcm, default aes-gcm")
+	rekeyCmd.Flags().BoolVar(&plainKey, "plain", false, "decrypt credentials and store them in plain text")
+	rootCmd.AddCommand(rekeyCmd)
 }
 func main() {
 	err := rootCmd.Execute()
@@ -0,0 +1,252 @@
+package maintenance
+
+import (
+	"fmt"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kubackup/kubackup/internal/cron"
+	"github.com/kubackup/kubackup/internal/entity/v1/maintenance"
+	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	maintenanceDao "github.com/kubackup/kubackup/internal/service/v1/maintenance"
+	"github.com/kubackup/kubackup/pkg/utils"
+	resticProxy "github.com/kubackup/kubackup/restic_proxy"
+	"strings"
+	"time"
+)
+
+var maintenanceService maintenanceDao.Service
+
+func init() {
+	maintenanceService = maintenanceDao.GetService()
+}
+
+func createHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		var m maintenance.Maintenance
+		err := ctx.ReadJSON(&m)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = checkMaintenance(&m)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = maintenanceService.Create(&m, common.DBOptions{})
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		if m.Status == maintenance.RunningStatus {
+			err = cron.AddJob(m.ExecTimeCron, cron.MaintenanceJob{
+				MaintenanceId: m.Id,
+			})
+			if err != nil {
+				utils.Errore(ctx, err)
+				return
+			}
+		}
+		ctx.Values().Set("data", m.Id)
+	}
+}
+
+func updateHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		var m maintenance.Maintenance
+		err = ctx.ReadJSON(&m)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		_, err = maintenanceService.Get(id, common.DBOptions{})
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = checkMaintenance(&m)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		m.Id = id
+		// 校验进度和最近执行记录由执行结果更新
+		m.LastBucket = 0
+		m.LastRunTime = time.Time{}
+		m.LastOperationId = 0
+		err = maintenanceService.Update(&m, common.DBOptions{})
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		// Update 不会更新零值字段，以下字段允许清空，需单独更新
+		for field, value := range map[string]interface{}{
+			"SkipIfBusy":         m.SkipIfBusy,
+			"ReadData":           m.ReadData,
+			"ReadDataSubset":     m.ReadDataSubset,
+			"CheckUnused":        m.CheckUnused,
+			"WithCache":          m.WithCache,
+			"RotateBuckets":      m.RotateBuckets,
+			"MaxUnused":          m.MaxUnused,
+			"MaxRepackSize":      m.MaxRepackSize,
+			"RepackCachableOnly": m.RepackCachableOnly,
+			"ReadAllPacks":       m.ReadAllPacks,
+			"Hosts":              m.Hosts,
+			"Tags":               m.Tags,
+			"Paths":              m.Paths,
+		} {
+			err = maintenanceService.UpdateField(id, field, value, common.DBOptions{})
+			if err != nil {
+				utils.Errore(ctx, err)
+				return
+			}
+		}
+		cron.ClearJobByType(cron.JOB_TYPE_MAINTENANCE)
+		initMaintenance()
+		ctx.Values().Set("data", "")
+	}
+}
+
+func deleteHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = maintenanceService.Delete(id, common.DBOptions{})
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		cron.ClearJobByType(cron.JOB_TYPE_MAINTENANCE)
+		initMaintenance()
+		ctx.Values().Set("data", "")
+	}
+}
+
+func listHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		repoid, err := ctx.URLParamInt("repositoryId")
+		if err != nil {
+			repoid = 0
+		}
+		status, err := ctx.URLParamInt("status")
+		if err != nil {
+			status = 0
+		}
+		res, err := maintenanceService.List(repoid, status, common.DBOptions{})
+		if err != nil && err.Error() != "not found" {
+			utils.Errore(ctx, err)
+			return
+		}
+		ctx.Values().Set("data", res)
+	}
+}
+
+// runHandler 立即执行一次维护，返回操作记录id
+func runHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		m, err := maintenanceService.Get(id, common.DBOptions{})
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		operId, err := resticProxy.RunMaintenance(m)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		ctx.Values().Set("data", operId)
+	}
+}
+
+// checkMaintenance 校验维护类型、cron 表达式及参数
+func checkMaintenance(m *maintenance.Maintenance) error {
+	if m.RepositoryId <= 0 {
+		return fmt.Errorf("仓库id不能为空")
+	}
+	switch m.Type {
+	case maintenance.TypeCheck:
+		m.ReadDataSubset = strings.TrimSpace(m.ReadDataSubset)
+		if m.ReadData && m.ReadDataSubset != "" {
+			return fmt.Errorf("读取所有数据与读取部分数据不能同时设置")
+		}
+		if m.RotateBuckets < 0 || m.RotateBuckets > resticProxy.MaxRotateBuckets {
+			return fmt.Errorf("轮换分组数需在 0 到 %d 之间", resticProxy.MaxRotateBuckets)
+		}
+		if m.RotateBuckets > 0 && (m.ReadData || m.ReadDataSubset != "") {
+			return fmt.Errorf("轮换校验不能与读取所有数据或读取部分数据同时设置")
+		}
+	case maintenance.TypeCopy:
+		if m.TargetRepositoryId <= 0 || m.TargetRepositoryId == m.RepositoryId {
+			return fmt.Errorf("请选择其他存储库作为复制目标")
+		}
+		_, err := resticProxy.NewCopyOptions(m.TargetRepositoryId, m.Hosts, m.Tags, m.Paths)
+		if err != nil {
+			return err
+		}
+	case maintenance.TypePrune, maintenance.TypeRebuildIndex:
+	default:
+		return fmt.Errorf("不支持的维护类型：%d", m.Type)
+	}
+	if m.Status == -1 {
+		m.Status = maintenance.StopStatus
+	} else if m.Status == 0 {
+		m.Status = maintenance.RunningStatus
+	}
+	if m.ExecTimeCron == "" {
+		return fmt.Errorf("cron表达式不能为空")
+	}
+	_, err := cron.GetNextTimes(m.ExecTimeCron)
+	if err != nil {
+		return fmt.Errorf("cron表达式格式错误：%v", err)
+	}
+	return nil
+}
+
+func Install(parent iris.Party) {
+	// 存储库维护相关接口
+	sp := parent.Party("/maintenance")
+	// 新增
+	sp.Post("", createHandler())
+	// 修改
+	sp.Put("/:id", updateHandler())
+	// 删除
+	sp.Delete("/:id", deleteHandler())
+	// 列表
+	sp.Get("", listHandler())
+	// 立即执行
+	sp.Post("/:id/run", runHandler())
+	initMaintenance()
+}
+
+// initMaintenance 初始化存储库维护到定时任务
+func initMaintenance() {
+	ms, err := maintenanceService.List(0, maintenance.RunningStatus, common.DBOptions{})
+	if err != nil {
+		return
+	}
+	for _, m := range ms {
+		if m.ExecTimeCron == "" {
+			continue
+		}
+		err = cron.AddJob(m.ExecTimeCron, cron.MaintenanceJob{
+			MaintenanceId: m.Id,
+		})
+		if err != nil {
+			server.Logger().Error(err)
+		}
+	}
+}
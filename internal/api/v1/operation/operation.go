@@ -5,6 +5,7 @@ import (
 	"github.com/kataras/iris/v12/context"
 	"github.com/kubackup/kubackup/internal/service/v1/common"
 	operationDao "github.com/kubackup/kubackup/internal/service/v1/operation"
+	"github.com/kubackup/kubackup/internal/store/log"
 	"github.com/kubackup/kubackup/pkg/utils"
 )
 
@@ -35,8 +36,25 @@ func getLastHandler() iris.Handler {
 	}
 }
 
+func cancelHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		if !log.LogInfos.Cancel(id) {
+			utils.ErrorStr(ctx, "该id无正在进行中的任务")
+			return
+		}
+		ctx.Values().Set("data", "")
+	}
+}
+
 func Install(parent iris.Party) {
 	// 仓库相关接口
 	sp := parent.Party("/operation")
 	sp.Get("/last/:type/:repository", getLastHandler())
+	// 取消进行中的检查、清理等操作
+	sp.Post("/:id/cancel", cancelHandler())
 }
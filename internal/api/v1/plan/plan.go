@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
+	"github.com/kubackup/kubackup/internal/api/v1/task"
 	"github.com/kubackup/kubackup/internal/cron"
 	"github.com/kubackup/kubackup/internal/entity/v1/plan"
 	"github.com/kubackup/kubackup/internal/model"
@@ -11,6 +12,8 @@ import (
 	"github.com/kubackup/kubackup/internal/service/v1/common"
 	ser "github.com/kubackup/kubackup/internal/service/v1/plan"
 	"github.com/kubackup/kubackup/pkg/utils"
+	resticProxy "github.com/kubackup/kubackup/restic_proxy"
+	"strings"
 )
 
 var planServer ser.Service
@@ -26,8 +29,9 @@ func createHandler() iris.Handler {
 			utils.Errore(ctx, err)
 			return
 		}
-		if p.Path == "" {
-			utils.ErrorStr(ctx, "path不能为空")
+		err = checkPlan(&p)
+		if err != nil {
+			utils.Errore(ctx, err)
 			return
 		}
 		if p.Status == -1 {
@@ -71,6 +75,7 @@ func deleteHandler() iris.Handler {
 			utils.Errore(ctx, err)
 			return
 		}
+		task.CancelRetry(id)
 		cron.ClearJob()
 		initPlan()
 		ctx.Values().Set("data", "")
@@ -90,8 +95,9 @@ func updateHandler() iris.Handler {
 			utils.Errore(ctx, err)
 			return
 		}
-		if p.Path == "" {
-			utils.ErrorStr(ctx, "path不能为空")
+		err = checkPlan(&p)
+		if err != nil {
+			utils.Errore(ctx, err)
 			return
 		}
 		if p.Status == -1 {
@@ -108,12 +114,102 @@ func updateHandler() iris.Handler {
 			utils.Errore(ctx, err)
 			return
 		}
+		// Update 不会更新零值字段，以下字段允许清空，需单独更新
+		for field, value := range map[string]interface{}{
+			"Excludes":                p.Excludes,
+			"InsensitiveExcludes":     p.InsensitiveExcludes,
+			"ExcludeFiles":            p.ExcludeFiles,
+			"InsensitiveExcludeFiles": p.InsensitiveExcludeFiles,
+			"ExcludeIfPresent":        p.ExcludeIfPresent,
+			"ExcludeCaches":           p.ExcludeCaches,
+			"ExcludeLargerThan":       p.ExcludeLargerThan,
+			"ExcludeOtherFS":          p.ExcludeOtherFS,
+			"Tags":                    p.Tags,
+			"Host":                    p.Host,
+			"GroupBy":                 p.GroupBy,
+			"PreHook":                 p.PreHook,
+			"PostHook":                p.PostHook,
+			"HookTimeout":             p.HookTimeout,
+			"PreHookAbort":            p.PreHookAbort,
+			"SourceType":              p.SourceType,
+			"SourceCommand":           p.SourceCommand,
+			"StdinFilename":           p.StdinFilename,
+			"Priority":                p.Priority,
+			"CatchUp":                 p.CatchUp,
+			"CatchUpWindow":           p.CatchUpWindow,
+			"RetryMax":                p.RetryMax,
+			"RetryDelay":              p.RetryDelay,
+			"RetryBackoff":            p.RetryBackoff,
+		} {
+			err = planServer.UpdateField(id, field, value, common.DBOptions{})
+			if err != nil {
+				utils.Errore(ctx, err)
+				return
+			}
+		}
+		if p.Status != plan.RunningStatus {
+			task.CancelRetry(id)
+		}
 		cron.ClearJob()
 		initPlan()
 		ctx.Values().Set("data", "")
 	}
 }
 
+// checkPlan 校验目标存储库、备份来源、排除规则、快照参数及脚本，并将路径列表同步到 Path 以便搜索
+func checkPlan(p *plan.Plan) error {
+	p.RepositoryIds = p.GetRepositoryIds()
+	if len(p.RepositoryIds) == 0 {
+		return fmt.Errorf("存储库不能为空")
+	}
+	p.RepositoryId = p.RepositoryIds[0]
+	if p.FanOut == 0 {
+		p.FanOut = plan.FanOutSequential
+	}
+	if p.FanOut != plan.FanOutSequential && p.FanOut != plan.FanOutParallel {
+		return fmt.Errorf("不支持的执行方式：%d", p.FanOut)
+	}
+	paths := make([]string, 0)
+	if p.SourceType == plan.SourceTypeCommand {
+		p.SourceCommand = strings.TrimSpace(p.SourceCommand)
+		if p.SourceCommand == "" {
+			return fmt.Errorf("备份命令不能为空")
+		}
+		p.StdinFilename = strings.TrimSpace(p.StdinFilename)
+		if p.StdinFilename == "" {
+			p.StdinFilename = plan.DefaultStdinFilename
+		}
+		// 快照中只包含命令输出这一个文件
+		paths = append(paths, "/"+p.StdinFilename)
+	} else {
+		for _, path := range p.GetPaths() {
+			path = strings.TrimSpace(path)
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	p.Paths = paths
+	p.Path = strings.Join(paths, ",")
+	p.Host = strings.TrimSpace(p.Host)
+	p.PreHook = strings.TrimSpace(p.PreHook)
+	p.PostHook = strings.TrimSpace(p.PostHook)
+	if p.HookTimeout < 0 {
+		return fmt.Errorf("脚本超时时间不能小于0")
+	}
+	if p.CatchUpWindow < 0 {
+		return fmt.Errorf("补执行时限不能小于0")
+	}
+	if p.RetryMax < 0 || p.RetryDelay < 0 || p.RetryBackoff < 0 {
+		return fmt.Errorf("重试设置不能小于0")
+	}
+	opts, err := resticProxy.NewBackupOptions(p)
+	if err != nil {
+		return err
+	}
+	return resticProxy.VerifyBackupOptions(opts, paths)
+}
+
 func searchHandler() iris.Handler {
 	return func(ctx *context.Context) {
 		res := model.PageParam(ctx)
@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"fmt"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kubackup/kubackup/internal/entity/v1/repository"
@@ -11,6 +12,7 @@ import (
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
 	"github.com/kubackup/kubackup/pkg/utils"
 	resticProxy "github.com/kubackup/kubackup/restic_proxy"
+	"strings"
 )
 
 var policyService policyDao.Service
@@ -49,6 +51,11 @@ func createHandler() iris.Handler {
 			utils.ErrorStr(ctx, "path不能为空")
 			return
 		}
+		err = checkPolicy(&policy)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
 		err = policyService.Create(&policy, common.DBOptions{})
 		if err != nil {
 			utils.Errore(ctx, err)
@@ -82,14 +89,44 @@ func updateHandler() iris.Handler {
 		if err != nil {
 			return
 		}
-		forgetPolicy.Value = policy.Value
-		forgetPolicy.Type = policy.Type
+		err = checkPolicy(&policy)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
 		forgetPolicy.RepositoryId = policy.RepositoryId
 		err = policyService.Update(forgetPolicy, common.DBOptions{})
 		if err != nil {
 			utils.Errore(ctx, err)
 			return
 		}
+		// 保留规则、主机名及标签允许清空，Update 不会更新零值字段
+		for field, value := range map[string]interface{}{
+			"Host":          policy.Host,
+			"Tags":          policy.Tags,
+			"Type":          policy.Type,
+			"Value":         policy.Value,
+			"Last":          policy.Last,
+			"Hourly":        policy.Hourly,
+			"Daily":         policy.Daily,
+			"Weekly":        policy.Weekly,
+			"Monthly":       policy.Monthly,
+			"Yearly":        policy.Yearly,
+			"Within":        policy.Within,
+			"WithinHourly":  policy.WithinHourly,
+			"WithinDaily":   policy.WithinDaily,
+			"WithinWeekly":  policy.WithinWeekly,
+			"WithinMonthly": policy.WithinMonthly,
+			"WithinYearly":  policy.WithinYearly,
+			"KeepTags":      policy.KeepTags,
+			"GroupBy":       policy.GroupBy,
+		} {
+			err = policyService.UpdateField(id, field, value, common.DBOptions{})
+			if err != nil {
+				utils.Errore(ctx, err)
+				return
+			}
+		}
 		ctx.Values().Set("data", policy.Id)
 	}
 }
@@ -146,11 +183,11 @@ func doHanlder() iris.Handler {
 		if err != nil {
 			return
 		}
-		opt := resticProxy.ForgetOptions{
-			Prune:          true,
-			SnapshotFilter: restic.SnapshotFilter{Paths: []string{policy.Path}},
+		opt, err := newForgetOptions(policy, true)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
 		}
-		setType(policy.Type, policy.Value, &opt)
 		operid, err := resticProxy.RunForget(opt, policy.RepositoryId, []string{})
 		if err != nil {
 			utils.Errore(ctx, err)
@@ -192,11 +229,11 @@ func executeHandler() iris.Handler {
 		if err != nil {
 			return
 		}
-		opt := resticProxy.ForgetOptions{
-			Prune:          true,
-			SnapshotFilter: restic.SnapshotFilter{Paths: []string{policy.Path}},
+		opt, err := newForgetOptions(policy, true)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
 		}
-		setType(policy.Type, policy.Value, &opt)
 		err = resticProxy.RunForgetSync(opt, policy.RepositoryId, []string{})
 		if err != nil {
 			utils.Errore(ctx, err)
@@ -206,6 +243,65 @@ func executeHandler() iris.Handler {
 	}
 }
 
+// previewHandler 预览策略将保留及删除的快照
+func previewHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		// 设置当前语言
+		setCurrentLanguage(ctx)
+
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		policy, err := policyService.Get(id, common.DBOptions{})
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		previewPolicy(ctx, policy)
+	}
+}
+
+// previewBodyHandler 预览未保存的策略
+func previewBodyHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		// 设置当前语言
+		setCurrentLanguage(ctx)
+
+		var policy repository.ForgetPolicy
+		err := ctx.ReadJSON(&policy)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		if policy.RepositoryId <= 0 {
+			utils.ErrorStr(ctx, "仓库id不能为空")
+			return
+		}
+		err = checkPolicy(&policy)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		previewPolicy(ctx, &policy)
+	}
+}
+
+func previewPolicy(ctx *context.Context, policy *repository.ForgetPolicy) {
+	opt, err := newForgetOptions(policy, false)
+	if err != nil {
+		utils.Errore(ctx, err)
+		return
+	}
+	res, err := resticProxy.PreviewForget(opt, policy.RepositoryId)
+	if err != nil {
+		utils.Errore(ctx, err)
+		return
+	}
+	ctx.Values().Set("data", res)
+}
+
 func Install(parent iris.Party) {
 	// 仓库相关接口
 	sp := parent.Party("/policy")
@@ -219,6 +315,9 @@ func Install(parent iris.Party) {
 	sp.Delete("/:id", delHanlder())
 	// 立即执行
 	sp.Post("/do/:id", doHanlder())
+	// 预览
+	sp.Get("/:id/preview", previewHandler())
+	sp.Post("/preview", previewBodyHandler())
 }
 
 func DoPolicy() {
@@ -232,43 +331,151 @@ func DoPolicy() {
 	for _, rep := range reps {
 		policys, err := policyService.Search(rep.Id, "", common.DBOptions{})
 		if err != nil {
-			return
+			continue
+		}
+		// 作用于同一批快照的策略合并后执行一次 forget，每次 forget 后 prune
+		keys := make([]string, 0)
+		groups := make(map[string][]repository.ForgetPolicy)
+		for _, policy := range policys {
+			key := policy.Key()
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], policy)
 		}
-		for i, policy := range policys {
-			opt := resticProxy.ForgetOptions{
-				Prune:          i == (len(policys) - 1),
-				SnapshotFilter: restic.SnapshotFilter{Paths: []string{policy.Path}},
+		for _, key := range keys {
+			policy := repository.MergeForgetPolicies(groups[key])
+			opt, err := newForgetOptions(&policy, true)
+			if err != nil {
+				server.Logger().Error(err)
+				continue
 			}
-			setType(policy.Type, policy.Value, &opt)
 			err = resticProxy.RunForgetSync(opt, policy.RepositoryId, []string{})
 			if err != nil {
 				server.Logger().Error(err)
 			}
-			server.Logger().Infof("清理 %s 下 %s ， 保留最新 %d %s 快照", rep.Name, policy.Path, policy.Value, policy.Type)
+			server.Logger().Infof("清理 %s 下 %s", rep.Name, policy.Path)
 		}
 	}
 }
 
+// newForgetOptions 根据策略生成清理参数，按路径、主机名及标签过滤快照
+func newForgetOptions(policy *repository.ForgetPolicy, prune bool) (resticProxy.ForgetOptions, error) {
+	filter := restic.SnapshotFilter{}
+	for _, p := range strings.Split(policy.Path, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			filter.Paths = append(filter.Paths, p)
+		}
+	}
+	if policy.Host != "" {
+		filter.Hosts = []string{policy.Host}
+	}
+	if len(policy.Tags) > 0 {
+		filter.Tags = restic.TagLists{policy.Tags}
+	}
+	groupBy := policy.GroupBy
+	if groupBy == "" {
+		groupBy = resticProxy.DefaultGroupBy
+	}
+	groupByOptions, err := resticProxy.SplitSnapshotGroupBy(groupBy)
+	if err != nil {
+		return resticProxy.ForgetOptions{}, err
+	}
+	opt := resticProxy.ForgetOptions{
+		Prune:          prune,
+		SnapshotFilter: filter,
+		GroupBy:        groupByOptions,
+		Last:           resticProxy.ForgetPolicyCount(policy.Last),
+		Hourly:         resticProxy.ForgetPolicyCount(policy.Hourly),
+		Daily:          resticProxy.ForgetPolicyCount(policy.Daily),
+		Weekly:         resticProxy.ForgetPolicyCount(policy.Weekly),
+		Monthly:        resticProxy.ForgetPolicyCount(policy.Monthly),
+		Yearly:         resticProxy.ForgetPolicyCount(policy.Yearly),
+	}
+	// 兼容旧版本的单一规则
+	setType(policy.Type, policy.Value, &opt)
+	for _, w := range []struct {
+		value string
+		d     *restic.Duration
+	}{
+		{policy.Within, &opt.Within},
+		{policy.WithinHourly, &opt.WithinHourly},
+		{policy.WithinDaily, &opt.WithinDaily},
+		{policy.WithinWeekly, &opt.WithinWeekly},
+		{policy.WithinMonthly, &opt.WithinMonthly},
+		{policy.WithinYearly, &opt.WithinYearly},
+	} {
+		if w.value == "" {
+			continue
+		}
+		d, err := restic.ParseDuration(w.value)
+		if err != nil {
+			return resticProxy.ForgetOptions{}, fmt.Errorf("保留时长格式错误：%s", w.value)
+		}
+		*w.d = d
+	}
+	for _, t := range policy.KeepTags {
+		var tags restic.TagList
+		for _, tag := range strings.Split(t, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) > 0 {
+			opt.KeepTags = append(opt.KeepTags, tags)
+		}
+	}
+	return opt, nil
+}
+
+// checkPolicy 校验保留规则，至少需要一条规则
+func checkPolicy(policy *repository.ForgetPolicy) error {
+	for _, v := range []int{policy.Last, policy.Hourly, policy.Daily, policy.Weekly, policy.Monthly, policy.Yearly} {
+		if v < -1 {
+			return resticProxy.ErrNegativePolicyCount
+		}
+	}
+	opt, err := newForgetOptions(policy, false)
+	if err != nil {
+		return err
+	}
+	if opt.Last == 0 && opt.Hourly == 0 && opt.Daily == 0 && opt.Weekly == 0 && opt.Monthly == 0 && opt.Yearly == 0 &&
+		opt.Within.Zero() && opt.WithinHourly.Zero() && opt.WithinDaily.Zero() && opt.WithinWeekly.Zero() &&
+		opt.WithinMonthly.Zero() && opt.WithinYearly.Zero() && len(opt.KeepTags) == 0 {
+		return fmt.Errorf("至少需要设置一条保留规则")
+	}
+	return nil
+}
+
+// setType 旧版本单一规则，对应字段未设置时生效
 func setType(t string, value int, opt *resticProxy.ForgetOptions) {
 	v := resticProxy.ForgetPolicyCount(value)
 	switch t {
 	case "last":
-		opt.Last = v
-		break
+		if opt.Last == 0 {
+			opt.Last = v
+		}
 	case "hourly":
-		opt.Hourly = v
-		break
+		if opt.Hourly == 0 {
+			opt.Hourly = v
+		}
 	case "daily":
-		opt.Daily = v
-		break
+		if opt.Daily == 0 {
+			opt.Daily = v
+		}
 	case "weekly":
-		opt.Weekly = v
-		break
+		if opt.Weekly == 0 {
+			opt.Weekly = v
+		}
 	case "monthly":
-		opt.Monthly = v
-		break
+		if opt.Monthly == 0 {
+			opt.Monthly = v
+		}
 	case "yearly":
-		opt.Yearly = v
-		break
+		if opt.Yearly == 0 {
+			opt.Yearly = v
+		}
 	}
 }
@@ -1,22 +1,30 @@
 package repository
 
 import (
+	"fmt"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kubackup/kubackup/internal/entity/v1/repository"
+	"github.com/kubackup/kubackup/internal/model"
 	"github.com/kubackup/kubackup/internal/service/v1/common"
+	maintenanceDao "github.com/kubackup/kubackup/internal/service/v1/maintenance"
 	policyDao "github.com/kubackup/kubackup/internal/service/v1/policy"
 	repositoryDao "github.com/kubackup/kubackup/internal/service/v1/repository"
+	fileutil "github.com/kubackup/kubackup/pkg/file"
 	"github.com/kubackup/kubackup/pkg/utils"
 	resticProxy "github.com/kubackup/kubackup/restic_proxy"
+	"net/url"
 	"strconv"
+	"strings"
 )
 
 var policyService policyDao.Service
 var repositoryService repositoryDao.Service
+var maintenanceService maintenanceDao.Service
 
 func init() {
 	policyService = policyDao.GetService()
+	maintenanceService = maintenanceDao.GetService()
 	repositoryService = repositoryDao.GetService()
 }
 
@@ -32,19 +40,31 @@ func createHandler() iris.Handler {
 			utils.ErrorStr(ctx, "请输入密码")
 			return
 		}
-		
+		err = checkCredentials(&rep)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = checkLimits(&rep)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+
 		// 设置当前语言
 		lang := ctx.Values().GetString("language")
 		if lang == "" {
 			lang = ctx.GetHeader("Accept-Language")
 		}
 		resticProxy.SetCurrentLanguage(lang)
-		
+
 		option, _ := resticProxy.GetGlobalOptions(rep)
 		repo, err1 := resticProxy.OpenRepository(ctx, option)
 		if err1 != nil {
 			//仓库异常，重新初始化
-			version, err := resticProxy.RunInit(ctx, option)
+			// 用于复制快照的目标存储库，可使用源存储库的分块参数初始化
+			chunkerFrom := ctx.URLParamIntDefault("chunkerFrom", 0)
+			version, err := resticProxy.RunInit(ctx, option, chunkerFrom)
 			if err != nil {
 				utils.Errore(ctx, err)
 				return
@@ -93,6 +113,7 @@ func delHanlder() iris.Handler {
 			return
 		}
 		_ = policyService.DeleteByRepo(id, common.DBOptions{})
+		_ = maintenanceService.DeleteByRepo(id, common.DBOptions{})
 		ctx.Values().Set("data", "")
 	}
 }
@@ -133,19 +154,46 @@ func updateHandler() iris.Handler {
 		if rep.Endpoint != "" {
 			rep2.Endpoint = rep.Endpoint
 		}
+		if rep.ProjectID != "" {
+			rep2.ProjectID = rep.ProjectID
+		}
+		if rep.AccountName != "" {
+			rep2.AccountName = rep.AccountName
+		}
+		// GCS 的凭据文件为空时使用服务器的默认凭据，允许清空
+		if rep.AccountKey != "" || rep2.Type == repository.Gcs {
+			rep2.AccountKey = rep.AccountKey
+		}
+		if rep.AccountID != "" {
+			rep2.AccountID = rep.AccountID
+		}
 		rep2.PackSize = rep.PackSize
+		rep2.MaxConcurrency = rep.MaxConcurrency
+		rep2.UploadLimit = rep.UploadLimit
+		rep2.DownloadLimit = rep.DownloadLimit
+		rep2.LimitSchedules = rep.LimitSchedules
 		if rep2.Password == "" {
 			utils.ErrorStr(ctx, "请输入密码")
 			return
 		}
-		
+		err = checkCredentials(rep2)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = checkLimits(rep2)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+
 		// 设置当前语言
 		lang := ctx.Values().GetString("language")
 		if lang == "" {
 			lang = ctx.GetHeader("Accept-Language")
 		}
 		resticProxy.SetCurrentLanguage(lang)
-		
+
 		option, _ := resticProxy.GetGlobalOptions(*rep2)
 		_, err = resticProxy.OpenRepository(ctx, option)
 		if err != nil {
@@ -157,11 +205,137 @@ func updateHandler() iris.Handler {
 			utils.Errore(ctx, err)
 			return
 		}
+		// Update 会忽略零值，可清空的字段单独更新
+		err = repositoryService.UpdateField(id, "MaxConcurrency", rep2.MaxConcurrency, common.DBOptions{})
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		if rep2.Type == repository.Gcs && rep2.AccountKey == "" {
+			err = repositoryService.UpdateField(id, "AccountKey", "", common.DBOptions{})
+			if err != nil {
+				utils.Errore(ctx, err)
+				return
+			}
+		}
+		err = updateLimitFields(id, rep2)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
 		go resticProxy.InitRepository()
 		ctx.Values().Set("data", "")
 	}
 }
 
+// limitHandler 仅修改限速，立即对已加载的存储库生效，无需重新加载
+func limitHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		var rep repository.Repository
+		err := ctx.ReadJSON(&rep)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		_, err = repositoryService.Get(id, common.DBOptions{})
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = checkLimits(&rep)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = updateLimitFields(id, &rep)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		resticProxy.ApplyBandwidthLimits()
+		ctx.Values().Set("data", "")
+	}
+}
+
+// updateLimitFields 更新限速相关字段，不包括并发数
+func updateLimitFields(id int, rep *repository.Repository) error {
+	for field, value := range map[string]interface{}{
+		"UploadLimit":    rep.UploadLimit,
+		"DownloadLimit":  rep.DownloadLimit,
+		"LimitSchedules": rep.LimitSchedules,
+	} {
+		err := repositoryService.UpdateField(id, field, value, common.DBOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCredentials 校验各类型存储库必填的位置及凭据
+func checkCredentials(rep *repository.Repository) error {
+	switch rep.Type {
+	case repository.Azure:
+		if strings.Trim(rep.Bucket, "/ ") == "" {
+			return fmt.Errorf("容器不能为空")
+		}
+		if rep.AccountName == "" || rep.AccountKey == "" {
+			return fmt.Errorf("账号名称及账号密钥不能为空")
+		}
+	case repository.Gcs:
+		if strings.Trim(rep.Bucket, "/ ") == "" {
+			return fmt.Errorf("存储桶不能为空")
+		}
+		if rep.ProjectID == "" {
+			return fmt.Errorf("项目id不能为空")
+		}
+		// 为空时使用服务器的默认凭据
+		if rep.AccountKey != "" && !fileutil.Exist(rep.AccountKey) {
+			return fmt.Errorf("凭据文件%s不存在", rep.AccountKey)
+		}
+	case repository.B2:
+		if strings.Trim(rep.Bucket, "/ ") == "" {
+			return fmt.Errorf("存储桶不能为空")
+		}
+		if rep.AccountID == "" || rep.AccountKey == "" {
+			return fmt.Errorf("账号id及应用密钥不能为空")
+		}
+	case repository.Swift:
+		if strings.Trim(rep.Bucket, "/ ") == "" {
+			return fmt.Errorf("容器不能为空")
+		}
+		if _, err := url.ParseRequestURI(rep.Endpoint); err != nil {
+			return fmt.Errorf("认证地址格式错误：%v", err)
+		}
+		if rep.KeyId == "" || rep.Secret == "" {
+			return fmt.Errorf("用户名及密码不能为空")
+		}
+	case repository.Rclone:
+		if !strings.Contains(rep.Endpoint, ":") {
+			return fmt.Errorf("rclone 远程路径格式为 remote:path")
+		}
+	}
+	return nil
+}
+
+// checkLimits 校验限速及分时段限速
+func checkLimits(rep *repository.Repository) error {
+	if rep.UploadLimit < 0 || rep.DownloadLimit < 0 {
+		return fmt.Errorf("限速不能小于0")
+	}
+	for _, s := range rep.LimitSchedules {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func getHandler() iris.Handler {
 	return func(ctx *context.Context) {
 
@@ -188,6 +362,84 @@ func getHandler() iris.Handler {
 	}
 }
 
+func listKeysHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		keys, err := resticProxy.ListKeys(id)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		ctx.Values().Set("data", keys)
+	}
+}
+
+func addKeyHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		var info model.KeyInfo
+		err = ctx.ReadJSON(&info)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		keyid, err := resticProxy.AddKey(id, info.Password, info.UserName, info.HostName)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		ctx.Values().Set("data", keyid)
+	}
+}
+
+func removeKeyHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = resticProxy.RemoveKey(id, ctx.Params().Get("keyid"))
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		ctx.Values().Set("data", "")
+	}
+}
+
+// rotateKeyHandler 更换 kubackup 使用的密钥，完成后重新加载存储库
+func rotateKeyHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		var info model.KeyInfo
+		err = ctx.ReadJSON(&info)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = resticProxy.RotateKey(id, info.Password, info.UserName, info.HostName)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		go resticProxy.InitRepository()
+		ctx.Values().Set("data", "")
+	}
+}
+
 func Install(parent iris.Party) {
 	// 仓库相关接口
 	sp := parent.Party("/repository")
@@ -199,6 +451,13 @@ func Install(parent iris.Party) {
 	sp.Delete("/:id", delHanlder())
 	// 修改
 	sp.Put("/:id", updateHandler())
+	// 修改限速
+	sp.Put("/:id/limit", limitHandler())
 
 	sp.Get("/:id", getHandler())
+	// 密钥管理
+	sp.Get("/:id/keys", listKeysHandler())
+	sp.Post("/:id/keys", addKeyHandler())
+	sp.Delete("/:id/keys/:keyid", removeKeyHandler())
+	sp.Post("/:id/keys/rotate", rotateKeyHandler())
 }
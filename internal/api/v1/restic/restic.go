@@ -1,17 +1,20 @@
 package restic
 
 import (
-	"fmt"
 	"github.com/fanjindong/go-cache"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kubackup/kubackup/internal/consts"
 	"github.com/kubackup/kubackup/internal/model"
 	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	planDao "github.com/kubackup/kubackup/internal/service/v1/plan"
 	repositoryDao "github.com/kubackup/kubackup/internal/service/v1/repository"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
 	"github.com/kubackup/kubackup/pkg/utils"
 	resticProxy "github.com/kubackup/kubackup/restic_proxy"
+	"mime"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -19,9 +22,11 @@ import (
 )
 
 var repositoryService repositoryDao.Service
+var planService planDao.Service
 
 func init() {
 	repositoryService = repositoryDao.GetService()
+	planService = planDao.GetService()
 }
 
 // 设置当前语言
@@ -142,7 +147,37 @@ func snapshotsHandler() iris.Handler {
 				return
 			}
 		}
-		groupBy, err := SplitSnapshotGroupBy(groupby)
+		// 按计划过滤：使用计划的主机名、备份路径及标签
+		planId, err := ctx.URLParamInt("planId")
+		if err == nil && planId > 0 {
+			pl, err := planService.Get(planId, common.DBOptions{})
+			if err != nil {
+				utils.Errore(ctx, err)
+				return
+			}
+			if len(hosts) == 0 {
+				h := pl.Host
+				if h == "" {
+					h, err = os.Hostname()
+					if err != nil {
+						utils.Errore(ctx, err)
+						return
+					}
+				}
+				hosts = []string{h}
+			}
+			if len(paths) == 0 {
+				paths = pl.GetPaths()
+			}
+			if len(tags) == 0 && len(pl.Tags) > 0 {
+				tags = restic.TagLists{pl.Tags}
+			}
+		}
+		groupBy, err := resticProxy.SplitSnapshotGroupBy(groupby)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
 		opts := resticProxy.SnapshotOptions{
 			SnapshotFilter: restic.SnapshotFilter{Hosts: hosts, Paths: paths, Tags: tags},
 			Compact:        false,
@@ -258,7 +293,13 @@ func checkHandler() iris.Handler {
 			utils.Errore(ctx, err)
 			return
 		}
-		opt := resticProxy.CheckOptions{}
+		// 默认仅检查元数据，可指定读取全部或部分数据
+		opt := resticProxy.CheckOptions{
+			ReadData:       ctx.URLParamBoolDefault("readData", false),
+			ReadDataSubset: ctx.URLParamTrim("readDataSubset"),
+			CheckUnused:    ctx.URLParamBoolDefault("checkUnused", false),
+			WithCache:      ctx.URLParamBoolDefault("withCache", false),
+		}
 		id, err := resticProxy.RunCheck(opt, repository)
 		if err != nil {
 			utils.Errore(ctx, err)
@@ -268,6 +309,39 @@ func checkHandler() iris.Handler {
 	}
 }
 
+// copyHandler 复制快照到目标存储库，可按主机、标签、路径或快照id过滤
+func copyHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		// 设置当前语言
+		setCurrentLanguage(ctx)
+
+		repository, err := ctx.Params().GetInt("repository")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		target, err := ctx.Params().GetInt("target")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		opt, err := resticProxy.NewCopyOptions(target, ctx.URLParamTrim("host"), ctx.URLParamTrim("tag"), ctx.URLParamTrim("path"))
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		if ids := ctx.URLParamTrim("snapshotIds"); ids != "" {
+			opt.SnapshotIDs = strings.Split(ids, ",")
+		}
+		id, err := resticProxy.RunCopy(opt, repository)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		ctx.Values().Set("data", id)
+	}
+}
+
 func rebuildIndexHandler() iris.Handler {
 	return func(ctx *context.Context) {
 		// 设置当前语言
@@ -383,6 +457,136 @@ func forgetHandler() iris.Handler {
 	}
 }
 
+// diffHandler 比较两个快照，按路径前缀过滤并分页
+func diffHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		// 设置当前语言
+		setCurrentLanguage(ctx)
+
+		repository, err := ctx.Params().GetInt("repository")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		a := ctx.Params().Get("a")
+		b := ctx.Params().Get("b")
+		res := model.PageParam(ctx)
+		path := ctx.URLParam("path")
+		c := server.Cache()
+		key := consts.Key("diffHandler", strconv.Itoa(repository), a, b, path)
+		var diffRes *resticProxy.DiffRes
+		diffResCache, is := c.Get(key)
+		if !is {
+			diffRes, err = resticProxy.RunDiff(repository, a, b, path)
+			if err != nil {
+				utils.Errore(ctx, err)
+				return
+			}
+			c.Set(key, *diffRes, cache.WithEx(10*time.Minute))
+		} else {
+			diffRes2, ok := diffResCache.(resticProxy.DiffRes)
+			if !ok {
+				utils.ErrorStr(ctx, "缓存读取失败")
+				return
+			}
+			diffRes = &diffRes2
+		}
+		total, result, err := model.PageFilter(res.PageNum, res.PageSize, diffRes.Nodes)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		res.Total = total
+		diffRes.Nodes = result
+		res.Items = diffRes
+		ctx.Values().Set("data", res)
+	}
+}
+
+// versionsHandler 列出文件在所有快照中的历史版本
+func versionsHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		// 设置当前语言
+		setCurrentLanguage(ctx)
+
+		repository, err := ctx.Params().GetInt("repository")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		res := model.PageParam(ctx)
+		path := ctx.URLParam("path")
+		if path == "" {
+			utils.ErrorStr(ctx, "path不能为空")
+			return
+		}
+		versions, err := resticProxy.RunFileVersions(repository, path, ctx.URLParam("host"))
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		total, result, err := model.PageFilter(res.PageNum, res.PageSize, versions)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		res.Total = total
+		res.Items = result
+		ctx.Values().Set("data", res)
+	}
+}
+
+// dumpHandler 从快照中下载文件或目录，文件支持 Range，目录按 tar/zip 打包
+func dumpHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		// 设置当前语言
+		setCurrentLanguage(ctx)
+
+		repository, err := ctx.Params().GetInt("repository")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		snapshotid := ctx.Params().Get("snapshotid")
+		archive := ctx.URLParam("archive")
+		reqCtx := ctx.Request().Context()
+		target, err := resticProxy.OpenDump(reqCtx, repository, snapshotid, ctx.URLParam("path"))
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		if target.IsDir && archive == "" {
+			archive = resticProxy.DumpArchiveTar
+		}
+		if archive != "" && archive != resticProxy.DumpArchiveTar && archive != resticProxy.DumpArchiveZip {
+			utils.ErrorStr(ctx, "打包格式仅支持 tar、zip")
+			return
+		}
+		name := target.Name
+		if archive != "" {
+			name += "." + archive
+		}
+		ctx.Header("Content-Type", server.ContentTypeDownload)
+		ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		if archive == "" {
+			reader, err := target.NewFileReader(reqCtx)
+			if err != nil {
+				ctx.Header("Content-Type", "")
+				ctx.Header("Content-Disposition", "")
+				utils.Errore(ctx, err)
+				return
+			}
+			http.ServeContent(ctx.ResponseWriter(), ctx.Request(), target.Name, target.ModTime, reader)
+			return
+		}
+		err = target.WriteArchive(reqCtx, archive, ctx.ResponseWriter())
+		if err != nil {
+			// 已开始写入数据，无法再返回错误信息
+			server.Logger().Errorf("dump %s of snapshot %s failed: %v", target.Path, snapshotid, err)
+		}
+	}
+}
+
 func Install(parent iris.Party) {
 	// restic 直接操作接口
 	sp := parent.Party("/restic")
@@ -401,22 +605,12 @@ func Install(parent iris.Party) {
 	sp.Post("/:repository/forget", forgetHandler())
 	sp.Post("/:repository/migrate", migrateHandler())
 	sp.Post("/:repository/unlock", unlockHandler())
-}
-
-func SplitSnapshotGroupBy(s string) (restic.SnapshotGroupByOptions, error) {
-	var l restic.SnapshotGroupByOptions
-	for _, option := range strings.Split(s, ",") {
-		switch option {
-		case "host", "hosts":
-			l.Host = true
-		case "path", "paths":
-			l.Path = true
-		case "tag", "tags":
-			l.Tag = true
-		case "":
-		default:
-			return restic.SnapshotGroupByOptions{}, fmt.Errorf("unknown grouping option: %q", option)
-		}
-	}
-	return l, nil
+	// 复制快照到其他存储库
+	sp.Post("/:repository/copy/:target", copyHandler())
+	// 比较两个快照
+	sp.Get("/:repository/diff/:a/:b", diffHandler())
+	// 文件历史版本
+	sp.Get("/:repository/versions", versionsHandler())
+	// 下载快照中的文件或目录
+	sp.Get("/:repository/dump/:snapshotid", dumpHandler())
 }
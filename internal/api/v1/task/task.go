@@ -1,9 +1,12 @@
 package task
 
 import (
+	"errors"
+	"fmt"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kubackup/kubackup/internal/consts"
+	planModel "github.com/kubackup/kubackup/internal/entity/v1/plan"
 	thmodel "github.com/kubackup/kubackup/internal/entity/v1/task"
 	"github.com/kubackup/kubackup/internal/model"
 	"github.com/kubackup/kubackup/internal/server"
@@ -11,12 +14,12 @@ import (
 	"github.com/kubackup/kubackup/internal/service/v1/plan"
 	ser "github.com/kubackup/kubackup/internal/service/v1/task"
 	"github.com/kubackup/kubackup/internal/store/task"
-	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
 	"github.com/kubackup/kubackup/pkg/utils"
 	resticProxy "github.com/kubackup/kubackup/restic_proxy"
-	"path/filepath"
+	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,7 +40,21 @@ func setCurrentLanguage(ctx *context.Context) {
 	resticProxy.SetCurrentLanguage(lang)
 }
 
+// backupHandler 返回第一个目标存储库的任务id，兼容单存储库的计划
 func backupHandler() iris.Handler {
+	return backupTasksHandler(func(taskids []int) interface{} {
+		return taskids[0]
+	})
+}
+
+// backupAllHandler 返回所有目标存储库的任务id
+func backupAllHandler() iris.Handler {
+	return backupTasksHandler(func(taskids []int) interface{} {
+		return taskids
+	})
+}
+
+func backupTasksHandler(data func(taskids []int) interface{}) iris.Handler {
 	return func(ctx *context.Context) {
 		// 设置当前语言
 		setCurrentLanguage(ctx)
@@ -47,13 +64,12 @@ func backupHandler() iris.Handler {
 			utils.Errore(ctx, err)
 			return
 		}
-		var taskid int
-		taskid, err = Backup(planid)
+		taskids, err := Backup(planid)
 		if err != nil {
 			utils.Errore(ctx, err)
 			return
 		}
-		ctx.Values().Set("data", taskid)
+		ctx.Values().Set("data", data(taskids))
 	}
 }
 
@@ -74,65 +90,21 @@ func restoreHandler() iris.Handler {
 			utils.Errore(ctx, err)
 			return
 		}
-		target := info.Target
-		if target == "" {
-			target = string(filepath.Separator)
-		}
-		path := info.Paths
-		host := info.Hosts
-		tag := info.Tags
-		exclude := info.Exclude
-		iexclude := info.IExclude
-		include := info.Include
-		iinclude := info.IInclude
-
-		var paths []string
-		if path != "" {
-			paths = strings.Split(path, ",")
-		}
-		var hosts []string
-		if host != "" {
-			hosts = strings.Split(host, ",")
-		}
-		var excludes []string
-		if exclude != "" {
-			excludes = strings.Split(exclude, ",")
-		}
-		var includes []string
-		if include != "" {
-			includes = strings.Split(include, ",")
-		}
-		var iexcludes []string
-		if iexclude != "" {
-			iexcludes = strings.Split(iexclude, ",")
-		}
-		var iincludes []string
-		if iinclude != "" {
-			iincludes = strings.Split(iinclude, ",")
-		}
-		tags := restic.TagLists{}
-		if tag != "" {
-			err := tags.Set(tag)
-			if err != nil {
-				utils.Errore(ctx, err)
-				return
-			}
-		}
-		opts := resticProxy.RestoreOptions{
-			Exclude:            excludes,
-			InsensitiveExclude: iexcludes,
-			Include:            includes,
-			InsensitiveInclude: iincludes,
-			Target:             target,
-			SnapshotFilter:     restic.SnapshotFilter{Hosts: hosts, Paths: paths, Tags: tags},
-			Verify:             info.Verify,
+		opts, err := resticProxy.NewRestoreOptions(info)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
 		}
 
-		err = resticProxy.RunRestore(opts, repository, snapshotid)
+		report, err := resticProxy.RunRestore(opts, repository, snapshotid)
 		if err != nil {
 			utils.Errore(ctx, err)
 			return
 		}
+		if opts.DryRun {
+			ctx.Values().Set("data", report)
+			return
+		}
 		ctx.Values().Set("data", "")
 	}
 }
@@ -187,7 +159,7 @@ func ClearTaskRunning() {
 		1, 10, -1, 0, 0, "", "", opt)
 	errTasks := make([]thmodel.Task, 0)
 	for _, t := range taskHistories {
-		if t.Status == task.StatusRunning {
+		if t.Status == task.StatusRunning || t.Status == task.StatusQueued {
 			ta := task.TaskInfos.Get(t.Id)
 			if ta == nil && t.Summary == nil {
 				t.Status = task.StatusError
@@ -213,16 +185,239 @@ func ClearTaskRunning() {
 	}
 }
 
-// Backup 备份数据 ，planid计划id
-func Backup(planid int) (int, error) {
+// Backup 备份数据 ，planid计划id。每个目标存储库创建一个任务，按计划的执行方式在后台执行
+func Backup(planid int) ([]int, error) {
+	tasks, _, err := backupPlan(planid, false)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(tasks))
+	for _, ta := range tasks {
+		ids = append(ids, ta.Id)
+	}
+	return ids, nil
+}
+
+// BackupWithRetry 执行定时备份并等待所有目标存储库结束，失败时按计划的重试设置延迟重新执行，
+// 重试任务通过 RetryOf 关联到该存储库首次执行的任务。计划有未结束的重试时跳过本次执行
+func BackupWithRetry(planid int) error {
+	if retryingPlans.pending(planid) {
+		server.Logger().Warnf("计划%d的上次备份正在等待重试，跳过本次执行", planid)
+		return nil
+	}
+	_, done, err := backupPlan(planid, true)
+	if err != nil {
+		return err
+	}
+	return <-done
+}
+
+// backupPlan 为计划的每个目标存储库创建任务，依次或同时执行，全部结束后汇总计划的执行结果。
+// 任务记录创建后即返回，所有存储库结束后 done 返回失败原因
+func backupPlan(planid int, retry bool) ([]*thmodel.Task, chan error, error) {
 	// 设置当前语言，由于没有context参数，使用默认语言
 	resticProxy.SetCurrentLanguage("")
-	
+
 	pl, err := planService.Get(planid, common.DBOptions{})
 	if err != nil {
-		return 0, err
+		return nil, nil, err
+	}
+	repoids := pl.GetRepositoryIds()
+	if len(repoids) == 0 {
+		return nil, nil, fmt.Errorf("计划%s未设置存储库", pl.Name)
+	}
+	tasks := make([]*thmodel.Task, 0, len(repoids))
+	for _, repoid := range repoids {
+		ta, err := newBackupTask(pl, repoid, 0, 0)
+		if err != nil {
+			for _, created := range tasks {
+				failBackupTask(created, err)
+			}
+			return nil, nil, err
+		}
+		tasks = append(tasks, ta)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		errs := make([]error, len(tasks))
+		if pl.FanOut == planModel.FanOutParallel {
+			var wg sync.WaitGroup
+			for i, ta := range tasks {
+				wg.Add(1)
+				go func(i int, ta *thmodel.Task) {
+					defer wg.Done()
+					errs[i] = backupTarget(pl, ta, retry)
+				}(i, ta)
+			}
+			wg.Wait()
+		} else {
+			for i, ta := range tasks {
+				errs[i] = backupTarget(pl, ta, retry)
+			}
+		}
+		done <- updatePlanStatus(pl, errs)
+	}()
+	return tasks, done, nil
+}
+
+// updatePlanStatus 汇总所有目标存储库的结果，部分失败时为 partial
+func updatePlanStatus(pl *planModel.Plan, errs []error) error {
+	failed := make([]error, 0)
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	status := planModel.LastStatusSuccess
+	if len(failed) == len(errs) {
+		status = planModel.LastStatusError
+	} else if len(failed) > 0 {
+		status = planModel.LastStatusPartial
+	}
+	now := time.Now()
+	fields := map[string]interface{}{
+		"LastStatus":  status,
+		"LastRunTime": now,
+	}
+	// 所有目标存储库都成功时才更新最近成功时间
+	if len(failed) == 0 {
+		fields["LastSuccessTime"] = now
+	}
+	for field, value := range fields {
+		err := planService.UpdateField(pl.Id, field, value, common.DBOptions{})
+		if err != nil {
+			server.Logger().Error(err)
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// retryingPlans 正在等待重试或执行重试的计划
+var retryingPlans = &planRetries{plans: make(map[int]*planRetry)}
+
+type planRetries struct {
+	sync.Mutex
+	plans map[int]*planRetry
+}
+
+// planRetry 计划的重试，cancel 关闭后等待中的重试立即结束
+type planRetry struct {
+	count  int
+	cancel chan struct{}
+}
+
+func (r *planRetries) add(planid int) *planRetry {
+	r.Lock()
+	defer r.Unlock()
+	pr, ok := r.plans[planid]
+	if !ok {
+		pr = &planRetry{cancel: make(chan struct{})}
+		r.plans[planid] = pr
+	}
+	pr.count++
+	return pr
+}
+
+func (r *planRetries) done(planid int, pr *planRetry) {
+	r.Lock()
+	defer r.Unlock()
+	pr.count--
+	if pr.count <= 0 && r.plans[planid] == pr {
+		delete(r.plans, planid)
+	}
+}
+
+func (r *planRetries) pending(planid int) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.plans[planid] != nil
+}
+
+// CancelRetry 取消计划等待中的重试，计划停用或删除时调用
+func CancelRetry(planid int) {
+	retryingPlans.Lock()
+	defer retryingPlans.Unlock()
+	if pr, ok := retryingPlans.plans[planid]; ok {
+		close(pr.cancel)
+		delete(retryingPlans.plans, planid)
+	}
+}
+
+// waitRetry 等待 delay 后返回 true，重试被取消时返回 false
+func waitRetry(pr *planRetry, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-pr.cancel:
+		return false
+	}
+}
+
+// backupTarget 执行计划在一个存储库上的备份并等待结束，retry 为 true 时失败后按计划的重试设置重试。
+// 仅未生成快照的失败会重试，已生成快照但有部分文件出错的不重试
+func backupTarget(pl *planModel.Plan, ta *thmodel.Task, retry bool) error {
+	done, err := runBackupTask(pl, ta)
+	origin := ta.Id
+	planid := pl.Id
+	var pr *planRetry
+	defer func() {
+		if pr != nil {
+			retryingPlans.done(planid, pr)
+		}
+	}()
+	for attempt := 1; ; attempt++ {
+		if err == nil {
+			<-done
+			ta, err = taskService.Get(ta.Id, common.DBOptions{})
+			if err != nil {
+				return err
+			}
+			if ta.Status == task.StatusEnd {
+				return nil
+			}
+			if ta.Status != task.StatusError {
+				return fmt.Errorf("备份任务%s已取消", ta.Name)
+			}
+			if ta.Summary != nil {
+				return fmt.Errorf("备份任务%s部分文件备份失败", ta.Name)
+			}
+			err = fmt.Errorf("备份任务%s执行失败", ta.Name)
+		}
+		if !retry {
+			return err
+		}
+		var perr error
+		pl, perr = planService.Get(planid, common.DBOptions{})
+		if perr != nil || pl.Status != planModel.RunningStatus || attempt > pl.RetryMax {
+			return err
+		}
+		if pr == nil {
+			pr = retryingPlans.add(planid)
+		}
+		delay := retryDelay(pl, attempt)
+		server.Logger().Warnf("%v，%s后第%d次重试", err, delay, attempt)
+		if !waitRetry(pr, delay) {
+			return fmt.Errorf("%v，重试已取消", err)
+		}
+		// 等待期间计划可能已停用、删除或修改，重新读取
+		pl, perr = planService.Get(planid, common.DBOptions{})
+		if perr != nil || pl.Status != planModel.RunningStatus {
+			return err
+		}
+		next, nerr := newBackupTask(pl, ta.RepositoryId, origin, attempt)
+		if nerr != nil {
+			return nerr
+		}
+		ta = next
+		done, err = runBackupTask(pl, ta)
 	}
-	repoid := pl.RepositoryId
+}
+
+// newBackupTask 创建计划在存储库 repoid 上的备份任务记录，retryOf 不为0时为重试任务
+func newBackupTask(pl *planModel.Plan, repoid, retryOf, attempt int) (*thmodel.Task, error) {
 	progress := &model.StatusUpdate{
 		MessageType:      "status",
 		SecondsElapsed:   "0",
@@ -234,35 +429,108 @@ func Backup(planid int) (int, error) {
 		ErrorCount:       0,
 	}
 	ta := &thmodel.Task{
-		Path:            pl.Path,
-		Name:            "backup_" + strconv.Itoa(planid) + "_" + strconv.Itoa(repoid) + "_" + time.Now().Format(consts.TaskHistoryName),
+		Path:            strings.Join(pl.GetPaths(), ","),
+		Name:            "backup_" + strconv.Itoa(pl.Id) + "_" + strconv.Itoa(repoid) + "_" + time.Now().Format(consts.TaskHistoryName),
 		Status:          task.StatusNew,
 		RepositoryId:    repoid,
 		Progress:        progress,
 		PlanId:          pl.Id,
 		ReadConcurrency: pl.ReadConcurrency,
+		RetryOf:         retryOf,
+		Attempt:         attempt,
 	}
-	err = taskService.Create(ta, common.DBOptions{})
+	err := taskService.Create(ta, common.DBOptions{})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	opt := resticProxy.BackupOptions{UseFsSnapshot: true, ReadConcurrency: pl.ReadConcurrency}
+	return ta, nil
+}
+
+// runBackupTask 执行备份任务，各存储库按自身的快照查找父快照。
+// done 在任务结束时关闭，启动失败时任务记录为失败
+func runBackupTask(pl *planModel.Plan, ta *thmodel.Task) (chan struct{}, error) {
+	opt, err := resticProxy.NewBackupOptions(pl)
+	if err != nil {
+		failBackupTask(ta, err)
+		return nil, err
+	}
+	opt.UseFsSnapshot = true
 	taskInfo := task.TaskInfo{
 		Name: ta.Name,
 		Path: ta.Path,
 	}
 	taskInfo.SetId(ta.Id)
-	err = resticProxy.RunBackup(opt, repoid, taskInfo)
+	done := make(chan struct{})
+	taskInfo.SetDone(done)
+	err = resticProxy.RunBackup(opt, ta.RepositoryId, pl.GetPaths(), taskInfo)
 	if err != nil {
-		ta.ArchivalError = append(ta.ArchivalError, model.ErrorUpdate{
-			MessageType: "error",
-			Error:       err.Error(),
-		})
-		ta.Status = task.StatusError
-		_ = taskService.Update(ta, common.DBOptions{})
-		return 0, err
-	}
-	return taskInfo.GetId(), nil
+		failBackupTask(ta, err)
+		return nil, err
+	}
+	return done, nil
+}
+
+func failBackupTask(ta *thmodel.Task, err error) {
+	ta.ArchivalError = append(ta.ArchivalError, model.ErrorUpdate{
+		MessageType: "error",
+		Error:       err.Error(),
+	})
+	ta.Status = task.StatusError
+	_ = taskService.Update(ta, common.DBOptions{})
+}
+
+// maxRetryDelay 重试等待时间上限
+const maxRetryDelay = 6 * time.Hour
+
+// retryDelay 第 attempt 次重试前的等待时间，按倍数递增
+func retryDelay(pl *planModel.Plan, attempt int) time.Duration {
+	delay := pl.RetryDelay
+	if delay <= 0 {
+		delay = 60
+	}
+	backoff := pl.RetryBackoff
+	if backoff < 1 {
+		backoff = 2
+	}
+	d := time.Duration(float64(delay) * math.Pow(backoff, float64(attempt-1)) * float64(time.Second))
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+func cancelHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		if !task.TaskInfos.Cancel(id) {
+			utils.ErrorStr(ctx, "该id无正在进行中的任务")
+			return
+		}
+		ctx.Values().Set("data", "")
+	}
+}
+
+// resumeHandler 继续中断的还原任务，已完整写入的文件会被跳过
+func resumeHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		setCurrentLanguage(ctx)
+
+		id, err := ctx.Params().GetInt("id")
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		err = resticProxy.ResumeRestore(id)
+		if err != nil {
+			utils.Errore(ctx, err)
+			return
+		}
+		ctx.Values().Set("data", "")
+	}
 }
 
 func Install(parent iris.Party) {
@@ -270,8 +538,14 @@ func Install(parent iris.Party) {
 	taskParty := parent.Party("/task")
 	// 新增备份任务
 	taskParty.Post("/backup/:planid", backupHandler())
+	// 新增备份任务，返回所有目标存储库的任务
+	taskParty.Post("/backup/:planid/all", backupAllHandler())
 	// 新增恢复任务
 	taskParty.Post("/:repository/restore/:snapshotid/", restoreHandler())
 	// 搜索任务
 	taskParty.Get("", searchHandler())
+	// 取消进行中的任务
+	taskParty.Post("/:id/cancel", cancelHandler())
+	// 继续中断的还原任务
+	taskParty.Post("/:id/resume", resumeHandler())
 }
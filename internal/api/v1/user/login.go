@@ -20,10 +20,11 @@ import (
 var userService user.Service
 var logService logser.Service
 
-var pwderrKey = "PwdErrCount:"
+// PwdErrKey 密码错误计数缓存前缀，WebDAV 的账号密码认证共用
+var PwdErrKey = "PwdErrCount:"
 
-// Second
-var lockTime = 1800
+// LockTime 密码连续错误后的锁定时间，单位秒
+var LockTime = 1800
 
 func init() {
 	userService = user.GetService()
@@ -93,25 +94,25 @@ func loginHandler() iris.Handler {
 
 func login(ctx *context.Context, username, password string) *sysuser.SysUser {
 	u, err := userService.GetByUserName(username, common.DBOptions{})
-	errk := pwderrKey + username
+	errk := PwdErrKey + username
 	count, ok := utils.Get(errk)
 	if !ok || count == nil {
 		count = 0
 	}
 	errcount := count.(int)
 	if errcount >= 3 {
-		utils.ErrorStr(ctx, fmt.Sprintf(consts.LockErrstr, errcount, lockTime/60))
-		utils.Set(errk, errcount, lockTime)
+		utils.ErrorStr(ctx, fmt.Sprintf(consts.LockErrstr, errcount, LockTime/60))
+		utils.Set(errk, errcount, LockTime)
 		return nil
 	}
 	if err != nil || u == nil {
 		utils.ErrorStr(ctx, consts.Pwderrstr)
-		utils.Set(errk, errcount+1, lockTime)
+		utils.Set(errk, errcount+1, LockTime)
 		return nil
 	}
 	if !utils.ComparePwd(password, u.Password) {
 		utils.ErrorStr(ctx, consts.Pwderrstr)
-		utils.Set(errk, errcount+1, lockTime)
+		utils.Set(errk, errcount+1, LockTime)
 		return nil
 	}
 	return u
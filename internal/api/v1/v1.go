@@ -5,6 +5,7 @@ import (
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kubackup/kubackup/internal/api/v1/dashboard"
+	"github.com/kubackup/kubackup/internal/api/v1/maintenance"
 	"github.com/kubackup/kubackup/internal/api/v1/operation"
 	"github.com/kubackup/kubackup/internal/api/v1/plan"
 	"github.com/kubackup/kubackup/internal/api/v1/policy"
@@ -120,5 +121,6 @@ func AddV1Route(app iris.Party) {
 	dashboard.Install(v1Party)
 	operation.Install(v1Party)
 	policy.Install(v1Party)
+	maintenance.Install(v1Party)
 	ws.Install(v1Party)
 }
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	userApi "github.com/kubackup/kubackup/internal/api/v1/user"
+	"github.com/kubackup/kubackup/internal/model"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"github.com/kubackup/kubackup/internal/service/v1/user"
+	"github.com/kubackup/kubackup/pkg/utils"
+	resticProxy "github.com/kubackup/kubackup/restic_proxy"
+	"strings"
+)
+
+const webdavPrefix = "/api/webdav"
+
+// 密码校验通过后缓存的时间，避免每个请求都计算 bcrypt
+const webdavAuthCacheTime = 300
+
+// 只读服务，写入类方法由 webdav 返回 403
+var webdavMethods = "OPTIONS GET HEAD PROPFIND PROPPATCH LOCK UNLOCK PUT DELETE MKCOL COPY MOVE"
+
+// webdavAuthHandler 支持 Bearer token，或 Basic 认证（密码为 token 或账号密码，开启 MFA 的账号只能使用 token）
+func webdavAuthHandler() iris.Handler {
+	verifier := utils.GetJwtVerifier()
+	verifyToken := func(token string) *model.Userinfo {
+		vt, err := verifier.VerifyToken([]byte(token))
+		if err != nil {
+			return nil
+		}
+		var u model.Userinfo
+		if err = vt.Claims(&u); err != nil {
+			return nil
+		}
+		return &u
+	}
+	return func(ctx *context.Context) {
+		auth := ctx.GetHeader("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") {
+			if verifyToken(strings.TrimPrefix(auth, "Bearer ")) != nil {
+				ctx.Next()
+				return
+			}
+		} else if username, password, ok := ctx.Request().BasicAuth(); ok {
+			if u := verifyToken(password); u != nil && u.Username == username {
+				ctx.Next()
+				return
+			}
+			if webdavLogin(username, password) {
+				ctx.Next()
+				return
+			}
+		}
+		ctx.Header("WWW-Authenticate", `Basic realm="kubackup"`)
+		ctx.StopWithStatus(iris.StatusUnauthorized)
+	}
+}
+
+// webdavLogin 校验账号密码，连续错误后锁定，与登录接口共用错误计数。
+// 校验通过后缓存账号的密码哈希，修改密码后缓存失效
+func webdavLogin(username, password string) bool {
+	errk := userApi.PwdErrKey + username
+	count, ok := utils.Get(errk)
+	if !ok || count == nil {
+		count = 0
+	}
+	errcount := count.(int)
+	if errcount >= 3 {
+		utils.Set(errk, errcount, userApi.LockTime)
+		return false
+	}
+	u, err := user.GetService().GetByUserName(username, common.DBOptions{})
+	if err != nil || u == nil {
+		utils.Set(errk, errcount+1, userApi.LockTime)
+		return false
+	}
+	if u.OtpSecret != "" && u.OtpSecret != "1" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(username + ":" + password))
+	authKey := "WebdavAuth:" + hex.EncodeToString(sum[:])
+	if hash, ok := utils.Get(authKey); ok && hash == u.Password {
+		return true
+	}
+	if !utils.ComparePwd(password, u.Password) {
+		utils.Set(errk, errcount+1, userApi.LockTime)
+		return false
+	}
+	utils.Set(authKey, u.Password, webdavAuthCacheTime)
+	return true
+}
+
+// webdavDepthHandler 禁止 Depth: infinity 的 PROPFIND，避免遍历整个快照
+func webdavDepthHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		if ctx.Method() == "PROPFIND" && strings.EqualFold(ctx.GetHeader("Depth"), "infinity") {
+			ctx.StopWithStatus(iris.StatusForbidden)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func AddWebdavRoute(app iris.Party) {
+	// 只读 WebDAV，目录结构为 /<存储库>/<快照时间>/<路径>
+	h := iris.FromStd(resticProxy.NewWebdavHandler(webdavPrefix))
+	p := app.Party("/webdav", webdavAuthHandler(), webdavDepthHandler())
+	p.HandleMany(webdavMethods, "/", h)
+	p.HandleMany(webdavMethods, "/{p:path}", h)
+}
@@ -0,0 +1,60 @@
+package gcs
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/options"
+)
+
+// Config 与 restic 的 gs 后端相同，另外支持为每个存储库指定凭据文件
+type Config struct {
+	ProjectID       string
+	Bucket          string
+	Prefix          string
+	CredentialsFile string //服务账号凭据文件路径，为空时使用服务器的默认凭据
+
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Region      string `option:"region" help:"region to create the bucket in (default: us)"`
+}
+
+func init() {
+	options.Register("gs", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+		Region:      "us",
+	}
+}
+
+// ParseConfig parses the string s and extracts the gcs config. The
+// supported configuration format is gs:bucketName:/[prefix].
+func ParseConfig(s string) (*Config, error) {
+	if !strings.HasPrefix(s, "gs:") {
+		return nil, errors.New("gs: invalid format")
+	}
+	s = s[3:]
+
+	bucket, prefix, colon := strings.Cut(s, ":")
+	if !colon {
+		return nil, errors.New("gs: invalid format: bucket name or path not found")
+	}
+	prefix = strings.TrimPrefix(path.Clean(prefix), "/")
+
+	cfg := NewConfig()
+	cfg.Bucket = bucket
+	cfg.Prefix = prefix
+	return &cfg, nil
+}
+
+// ApplyEnvironment saves values from the environment to the config.
+func (cfg *Config) ApplyEnvironment(prefix string) {
+	if cfg.ProjectID == "" {
+		cfg.ProjectID = os.Getenv(prefix + "GOOGLE_PROJECT_ID")
+	}
+}
@@ -0,0 +1,229 @@
+package gcs
+
+import (
+	"context"
+	"crypto/md5"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/layout"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/location"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+var _ restic.Backend = &Gcs{}
+
+// Gcs Google Cloud Storage 后端，按 restic 的 gs 后端实现。
+// restic 只能从进程环境变量读取凭据，多个存储库使用不同凭据文件时需修改环境变量，
+// 这里为每个存储库从凭据文件创建客户端，不影响同时执行的脚本等子进程
+type Gcs struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+	cfg    Config
+	layout.Layout
+}
+
+func NewFactory() location.Factory {
+	return location.NewHTTPBackendFactory("gs", ParseConfig, location.NoPassword, Create, Open)
+}
+
+// newClient 创建客户端，请求经过 rt 以保留限速等设置
+func newClient(cfg Config, rt http.RoundTripper) (*storage.Client, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: rt})
+
+	var ts oauth2.TokenSource
+	switch {
+	case cfg.CredentialsFile != "":
+		data, err := os.ReadFile(cfg.CredentialsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read credentials file")
+		}
+		creds, err := google.CredentialsFromJSON(ctx, data, storage.ScopeReadWrite)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse credentials file")
+		}
+		ts = creds.TokenSource
+	case os.Getenv("GOOGLE_ACCESS_TOKEN") != "":
+		ts = oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: os.Getenv("GOOGLE_ACCESS_TOKEN"),
+			TokenType:   "Bearer",
+		})
+	default:
+		var err error
+		ts, err = google.DefaultTokenSource(ctx, storage.ScopeReadWrite)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return storage.NewClient(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, ts)))
+}
+
+func Open(ctx context.Context, cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	return open(cfg, rt)
+}
+
+// Create 打开存储桶，不存在时创建
+func Create(ctx context.Context, cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	be, err := open(cfg, rt)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	_, err = be.bucket.Attrs(ctx)
+	if err == nil {
+		return be, nil
+	}
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusForbidden {
+		// 没有 storage.buckets.get 权限，存储桶可能已存在
+		return be, nil
+	}
+	if err != storage.ErrBucketNotExist {
+		return nil, errors.Wrap(err, "service.Buckets.Get")
+	}
+	err = be.bucket.Create(ctx, cfg.ProjectID, &storage.BucketAttrs{Location: cfg.Region})
+	if err != nil {
+		return nil, errors.Wrap(err, "service.Buckets.Insert")
+	}
+	return be, nil
+}
+
+func open(cfg Config, rt http.RoundTripper) (*Gcs, error) {
+	client, err := newClient(cfg, rt)
+	if err != nil {
+		return nil, errors.Wrap(err, "newClient")
+	}
+	return &Gcs{
+		client: client,
+		bucket: client.Bucket(cfg.Bucket),
+		cfg:    cfg,
+		Layout: &layout.DefaultLayout{
+			Path: cfg.Prefix,
+			Join: path.Join,
+		},
+	}, nil
+}
+
+func (g *Gcs) Connections() uint {
+	return g.cfg.Connections
+}
+
+func (g *Gcs) HasAtomicReplace() bool {
+	return true
+}
+
+func (g *Gcs) Location() string {
+	return g.Join(g.cfg.Bucket, g.cfg.Prefix)
+}
+
+func (g *Gcs) Hasher() hash.Hash {
+	return md5.New()
+}
+
+func (g *Gcs) Save(ctx context.Context, handle restic.Handle, rd restic.RewindReader) error {
+	w := g.bucket.Object(g.Filename(handle)).NewWriter(ctx)
+	// 不使用分块上传，避免缓冲使上传限速失效
+	w.ChunkSize = 0
+	w.MD5 = rd.Hash()
+	wbytes, err := io.Copy(w, rd)
+	cerr := w.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "service.Objects.Insert")
+	}
+	if wbytes != rd.Length() {
+		return errors.Errorf("wrote %d bytes instead of the expected %d bytes", wbytes, rd.Length())
+	}
+	return nil
+}
+
+func (g *Gcs) Load(ctx context.Context, handle restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	return backend.DefaultLoad(ctx, handle, length, offset, g.openReader, fn)
+}
+
+func (g *Gcs) openReader(ctx context.Context, handle restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	if length == 0 {
+		// 负数表示读取到文件末尾
+		length = -1
+	}
+	return g.bucket.Object(g.Filename(handle)).NewRangeReader(ctx, offset, int64(length))
+}
+
+func (g *Gcs) Stat(ctx context.Context, handle restic.Handle) (restic.FileInfo, error) {
+	attr, err := g.bucket.Object(g.Filename(handle)).Attrs(ctx)
+	if err != nil {
+		return restic.FileInfo{}, errors.Wrap(err, "service.Objects.Get")
+	}
+	return restic.FileInfo{Size: attr.Size, Name: handle.Name}, nil
+}
+
+func (g *Gcs) Remove(ctx context.Context, handle restic.Handle) error {
+	err := g.bucket.Object(g.Filename(handle)).Delete(ctx)
+	if g.IsNotExist(err) {
+		err = nil
+	}
+	return errors.Wrap(err, "client.RemoveObject")
+}
+
+func (g *Gcs) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	prefix, _ := g.Basedir(t)
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	itr := g.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := itr.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(attrs.Name, prefix)
+		if name == "" {
+			continue
+		}
+		err = fn(restic.FileInfo{Name: path.Base(name), Size: attrs.Size})
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
+
+func (g *Gcs) IsNotExist(err error) bool {
+	return errors.Is(err, storage.ErrObjectNotExist)
+}
+
+func (g *Gcs) Delete(ctx context.Context) error {
+	return backend.DefaultDelete(ctx, g)
+}
+
+func (g *Gcs) Close() error {
+	return g.client.Close()
+}
+
+// Join combines path components with slashes.
+func (g *Gcs) Join(p ...string) string {
+	return path.Join(p...)
+}
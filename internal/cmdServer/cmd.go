@@ -4,14 +4,18 @@ import (
 	"fmt"
 	"github.com/asdine/storm/v3"
 	cf "github.com/kubackup/kubackup/internal/config"
+	"github.com/kubackup/kubackup/internal/crypt"
 	"github.com/kubackup/kubackup/internal/entity/v1/config"
 	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"github.com/kubackup/kubackup/internal/service/v1/repository"
 	"github.com/kubackup/kubackup/internal/service/v1/user"
 	fileutil "github.com/kubackup/kubackup/pkg/file"
 	shell "github.com/kubackup/kubackup/pkg/utils/cmd"
+	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 )
 
@@ -99,3 +103,62 @@ func (cmd *Cmd) ClearPwd(username string, mode int) {
 		return
 	}
 }
+
+// Rekey 更换存储库凭据的主密钥，使用当前配置的主密钥解密，以 newKey 重新加密
+// newKey 新主密钥配置，不读取环境变量；plain 为 true 时解密为明文
+// mode 服务是否被临时关闭。更换成功后配置中仍是旧主密钥，不重新启动服务，需更新配置后手动启动；失败时数据未修改，重新启动服务
+func (cmd *Cmd) Rekey(newKey config.EncryptConfig, plain bool, mode int) {
+	if cmd.db == nil {
+		if runtime.GOOS == "linux" {
+			_, _ = shell.ExecWithTimeOut("systemctl stop kubackup.service", 1*time.Minute)
+			cmd.setUpDB()
+			cmd.Rekey(newKey, plain, 1)
+		} else {
+			fmt.Println("数据库繁忙，请手动关闭kubackup_server后再试")
+		}
+		return
+	}
+	count, err := cmd.rekey(newKey, plain)
+	if err != nil {
+		if mode == 1 && runtime.GOOS == "linux" {
+			_, _ = shell.ExecWithTimeOut("systemctl start kubackup.service", 1*time.Minute)
+		}
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("已处理%d个存储库的凭据。\n", count)
+	if plain {
+		fmt.Printf("请从配置及环境变量 %s 中删除主密钥，然后启动服务。\n", crypt.EnvMasterKey)
+	} else {
+		fmt.Printf("请将新主密钥写入配置或环境变量 %s，然后启动服务。\n", crypt.EnvMasterKey)
+	}
+	if mode == 1 {
+		fmt.Println("服务已停止，更新主密钥前请勿启动，否则将无法解密存储库凭据。")
+	}
+}
+
+func (cmd *Cmd) rekey(newKey config.EncryptConfig, plain bool) (int, error) {
+	from, err := crypt.NewCipher(cmd.config.Encrypt)
+	if err != nil {
+		return 0, err
+	}
+	var to *crypt.Cipher
+	if !plain {
+		key := newKey.Key
+		if newKey.KeyFile != "" {
+			b, err := os.ReadFile(fileutil.ReplaceHomeDir(newKey.KeyFile))
+			if err != nil {
+				return 0, fmt.Errorf("读取主密钥文件失败：%v", err)
+			}
+			key = strings.TrimSpace(string(b))
+		}
+		if key == "" {
+			return 0, fmt.Errorf("新主密钥不能为空")
+		}
+		to, err = crypt.NewCipherWithKey(newKey.Algorithm, key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return repository.GetService().EncryptCredentials(from, to, common.DBOptions{DB: cmd.db})
+}
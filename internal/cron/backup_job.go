@@ -3,14 +3,28 @@ package cron
 import (
 	"github.com/kubackup/kubackup/internal/api/v1/task"
 	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	planDao "github.com/kubackup/kubackup/internal/service/v1/plan"
+	"time"
 )
 
+var planService planDao.Service
+
+func init() {
+	planService = planDao.GetService()
+}
+
 type BackupJob struct {
 	PlanId int
 }
 
 func (b BackupJob) Run() {
-	_, err := task.Backup(b.PlanId)
+	// 记录触发时间，用于启动后判断是否错过执行
+	err := planService.UpdateField(b.PlanId, "LastScheduledTime", time.Now(), common.DBOptions{})
+	if err != nil {
+		server.Logger().Error(err)
+	}
+	err = task.BackupWithRetry(b.PlanId)
 	if err != nil {
 		server.Logger().Error(err)
 		return
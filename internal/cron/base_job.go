@@ -8,7 +8,7 @@ type BaseJob interface {
 }
 
 const (
-	JOB_TYPE_SYSTEM = 0 // 系统任务，不会被删除
-	JOB_TYPE_BACKUP = 1 // 备份任务
-	JOB_TYPE_PRUNE  = 2 // 清理任务
+	JOB_TYPE_SYSTEM      = 0 // 系统任务，不会被删除
+	JOB_TYPE_BACKUP      = 1 // 备份任务
+	JOB_TYPE_MAINTENANCE = 2 // 存储库维护任务
 )
@@ -0,0 +1,71 @@
+package cron
+
+import (
+	"github.com/kubackup/kubackup/internal/consts"
+	"github.com/kubackup/kubackup/internal/entity/v1/plan"
+	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	resticProxy "github.com/kubackup/kubackup/restic_proxy"
+	"github.com/robfig/cron/v3"
+	"time"
+)
+
+// DefaultCatchUpWindow 补执行的默认最大延迟
+const DefaultCatchUpWindow = 24 * time.Hour
+
+// maxCatchUpSteps 查找错过执行时间的最大步数，避免秒级表达式长时间循环
+const maxCatchUpSteps = 100000
+
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// catchUpMissedRuns 服务启动后补执行停机期间错过的定时备份，每个计划最多补执行一次
+func catchUpMissedRuns() {
+	resticProxy.WaitRepositoryLoaded()
+	plans, err := planService.List(plan.RunningStatus, common.DBOptions{})
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, p := range plans {
+		if !p.CatchUp || p.ExecTimeCron == "" {
+			continue
+		}
+		missed, err := lastMissedTime(p.ExecTimeCron, p.LastScheduledTime, now)
+		if err != nil {
+			server.Logger().Error(err)
+			continue
+		}
+		if missed.IsZero() {
+			continue
+		}
+		window := DefaultCatchUpWindow
+		if p.CatchUpWindow > 0 {
+			window = time.Duration(p.CatchUpWindow) * time.Minute
+		}
+		if now.Sub(missed) > window {
+			server.Logger().Infof("计划%s错过执行时间%s，超过补执行时限，跳过", p.Name, missed.Format(consts.Custom))
+			continue
+		}
+		server.Logger().Infof("计划%s错过执行时间%s，开始补执行", p.Name, missed.Format(consts.Custom))
+		go BackupJob{PlanId: p.Id}.Run()
+	}
+}
+
+// lastMissedTime 获取 since 之后、now 之前最后一次应执行的时间，没有时返回零值。
+// since 为零值时没有执行记录（如升级前创建的计划），不补执行，由下次定时执行记录
+func lastMissedTime(cronStr string, since, now time.Time) (time.Time, error) {
+	schedule, err := cronParser.Parse(CheckCron(cronStr))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if since.IsZero() {
+		return time.Time{}, nil
+	}
+	var missed time.Time
+	next := schedule.Next(since)
+	for i := 0; i < maxCatchUpSteps && !next.IsZero() && !next.After(now); i++ {
+		missed = next
+		next = schedule.Next(next)
+	}
+	return missed, nil
+}
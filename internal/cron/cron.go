@@ -18,6 +18,7 @@ func InitCron() {
 	c = cron.New(cron.WithSeconds())
 	initSystemCronJob()
 	c.Start()
+	go catchUpMissedRuns()
 	defer c.Stop()
 	select {}
 }
@@ -39,6 +40,13 @@ func initSystemCronJob() {
 	if err != nil {
 		fmt.Println(fmt.Errorf("ClearTaskRunning 定时任务启动失败：%s", err))
 	}
+	// 按时段更新存储库限速
+	_, err = c.AddJob("0 * * * * *", SystemJob(func() {
+		resticProxy.ApplyBandwidthLimits()
+	}))
+	if err != nil {
+		fmt.Println(fmt.Errorf("ApplyBandwidthLimits 定时任务启动失败：%s", err))
+	}
 	// 执行清理策略
 	_, err = c.AddJob("0 0 6 * * *", SystemJob(func() {
 		server.Logger().Info("执行清理策略")
@@ -58,10 +66,17 @@ func AddJob(cronStr string, job BaseJob) error {
 	return nil
 }
 
+// ClearJob 清除备份定时任务
 func ClearJob() {
+	ClearJobByType(JOB_TYPE_BACKUP)
+}
+
+// ClearJobByType 清除指定类型的定时任务，系统任务不会被清除
+func ClearJobByType(jobType int) {
 	entries := c.Entries()
 	for _, entry := range entries {
-		if entry.Job.(BaseJob).GetType() == JOB_TYPE_SYSTEM {
+		t := entry.Job.(BaseJob).GetType()
+		if t == JOB_TYPE_SYSTEM || t != jobType {
 			continue
 		}
 		c.Remove(entry.ID)
@@ -3,6 +3,7 @@ package cron
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestGetNextTimes(t *testing.T) {
@@ -21,3 +22,31 @@ func TestCheckCron(t *testing.T) {
 	}
 	fmt.Println(got)
 }
+
+func TestLastMissedTime(t *testing.T) {
+	since := time.Date(2024, 1, 1, 2, 0, 0, 0, time.Local)
+	now := time.Date(2024, 1, 3, 10, 0, 0, 0, time.Local)
+	got, err := lastMissedTime("0 0 2 * * *", since, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 1, 3, 2, 0, 0, 0, time.Local)
+	if !got.Equal(want) {
+		t.Errorf("lastMissedTime() = %v, want %v", got, want)
+	}
+	got, err = lastMissedTime("0 0 2 * * *", want, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Errorf("lastMissedTime() = %v, want zero", got)
+	}
+	// 没有执行记录时不补执行
+	got, err = lastMissedTime("0 0 2 * * *", time.Time{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Errorf("lastMissedTime() = %v, want zero", got)
+	}
+}
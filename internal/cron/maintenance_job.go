@@ -0,0 +1,51 @@
+package cron
+
+import (
+	"github.com/kubackup/kubackup/internal/entity/v1/maintenance"
+	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	maintenanceDao "github.com/kubackup/kubackup/internal/service/v1/maintenance"
+	resticProxy "github.com/kubackup/kubackup/restic_proxy"
+	"time"
+)
+
+var maintenanceService maintenanceDao.Service
+
+func init() {
+	maintenanceService = maintenanceDao.GetService()
+}
+
+// MaintenanceJob 存储库定时维护：检查、清理、重建索引
+type MaintenanceJob struct {
+	MaintenanceId int
+}
+
+func (b MaintenanceJob) Run() {
+	m, err := maintenanceService.Get(b.MaintenanceId, common.DBOptions{})
+	if err != nil {
+		server.Logger().Error(err)
+		return
+	}
+	if m.Status != maintenance.RunningStatus {
+		return
+	}
+	err = maintenanceService.UpdateField(m.Id, "LastRunTime", time.Now(), common.DBOptions{})
+	if err != nil {
+		server.Logger().Error(err)
+	}
+	operId, err := resticProxy.RunMaintenance(m)
+	if err != nil {
+		server.Logger().Errorf("存储库维护 %s 执行失败：%v", m.Name, err)
+		return
+	}
+	err = maintenanceService.UpdateField(m.Id, "LastOperationId", operId, common.DBOptions{})
+	if err != nil {
+		server.Logger().Error(err)
+	}
+}
+
+func (b MaintenanceJob) GetType() int {
+	return JOB_TYPE_MAINTENANCE
+}
+
+var _ BaseJob = &MaintenanceJob{}
@@ -0,0 +1,194 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/kubackup/kubackup/internal/entity/v1/config"
+	fileutil "github.com/kubackup/kubackup/pkg/file"
+	"github.com/kubackup/kubackup/pkg/utils"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// EnvMasterKey 主密钥环境变量
+const EnvMasterKey = "KUBACKUP_MASTER_KEY"
+
+// 加密算法
+const (
+	AesGcm = "aes-gcm"
+	Sm4Gcm = "sm4-gcm"
+)
+
+// 密文格式为 enc:<算法>:<base64 盐>:<base64 密文>，不符合该格式的值视为明文
+const prefix = "enc:"
+
+const saltSize = 16
+
+// Cipher 使用主密钥加解密数据库中的凭据
+type Cipher struct {
+	algorithm string
+	password  []byte
+	// 加密使用的盐及派生的密钥，每个实例随机生成
+	salt []byte
+	key  []byte
+	// 解密时按盐缓存派生的密钥，避免重复执行密钥派生
+	lock sync.Mutex
+	keys map[string][]byte
+}
+
+var defaultCipher *Cipher
+
+// SetDefault 设置服务使用的加密方式，为 nil 时不加密
+func SetDefault(c *Cipher) {
+	defaultCipher = c
+}
+
+// Default 服务使用的加密方式，未配置主密钥时为 nil
+func Default() *Cipher {
+	return defaultCipher
+}
+
+// NewCipher 按配置读取主密钥，未配置主密钥时返回 nil
+func NewCipher(c config.EncryptConfig) (*Cipher, error) {
+	key := os.Getenv(EnvMasterKey)
+	if key == "" && c.KeyFile != "" {
+		b, err := os.ReadFile(fileutil.ReplaceHomeDir(c.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("读取主密钥文件失败：%v", err)
+		}
+		key = strings.TrimSpace(string(b))
+	}
+	if key == "" {
+		key = c.Key
+	}
+	if key == "" {
+		return nil, nil
+	}
+	return NewCipherWithKey(c.Algorithm, key)
+}
+
+// NewCipherWithKey 由主密钥派生加密密钥，algorithm 为空时使用 aes-gcm。
+// aes-gcm 使用 scrypt，sm4-gcm 使用 PBKDF2-SM3，盐随密文保存
+func NewCipherWithKey(algorithm string, key string) (*Cipher, error) {
+	if algorithm == "" {
+		algorithm = AesGcm
+	}
+	if algorithm != AesGcm && algorithm != Sm4Gcm {
+		return nil, fmt.Errorf("不支持的加密算法：%s", algorithm)
+	}
+	c := &Cipher{
+		algorithm: algorithm,
+		password:  []byte(key),
+		salt:      make([]byte, saltSize),
+		keys:      make(map[string][]byte),
+	}
+	if _, err := rand.Read(c.salt); err != nil {
+		return nil, err
+	}
+	var err error
+	c.key, err = c.deriveKey(c.salt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cipher) deriveKey(salt []byte) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if key, ok := c.keys[string(salt)]; ok {
+		return key, nil
+	}
+	var key []byte
+	switch c.algorithm {
+	case Sm4Gcm:
+		key = pbkdf2.Key(c.password, salt, 100000, 16, utils.Sm3Hash)
+	default:
+		var err error
+		key, err = scrypt.Key(c.password, salt, 1<<15, 8, 1, 32)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.keys[string(salt)] = key
+	return key, nil
+}
+
+// parse 按密文格式拆分为算法、盐和密文
+func parse(s string) (algorithm string, salt []byte, sec []byte, ok bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return "", nil, nil, false
+	}
+	parts := strings.Split(strings.TrimPrefix(s, prefix), ":")
+	if len(parts) != 3 || (parts[0] != AesGcm && parts[0] != Sm4Gcm) {
+		return "", nil, nil, false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(salt) != saltSize {
+		return "", nil, nil, false
+	}
+	sec, err = base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(sec) == 0 {
+		return "", nil, nil, false
+	}
+	return parts[0], salt, sec, true
+}
+
+// IsEncrypted 是否为密文
+func IsEncrypted(s string) bool {
+	_, _, _, ok := parse(s)
+	return ok
+}
+
+// Encrypt 加密，c 为 nil 或值为空时原样返回。
+// 不根据前缀跳过，以 enc: 开头的明文同样加密
+func (c *Cipher) Encrypt(s string) (string, error) {
+	if c == nil || s == "" {
+		return s, nil
+	}
+	var sec []byte
+	var err error
+	switch c.algorithm {
+	case Sm4Gcm:
+		sec, err = utils.Sm4GcmEncrypt(c.key, []byte(s))
+	default:
+		sec, err = utils.AesGcmEncrypt(c.key, []byte(s))
+	}
+	if err != nil {
+		return "", err
+	}
+	return prefix + c.algorithm + ":" + base64.StdEncoding.EncodeToString(c.salt) + ":" + base64.StdEncoding.EncodeToString(sec), nil
+}
+
+// Decrypt 解密，明文原样返回
+func (c *Cipher) Decrypt(s string) (string, error) {
+	algorithm, salt, sec, ok := parse(s)
+	if !ok {
+		return s, nil
+	}
+	if c == nil {
+		return "", fmt.Errorf("凭据已加密，请配置主密钥")
+	}
+	if algorithm != c.algorithm {
+		return "", fmt.Errorf("凭据加密算法与配置不一致")
+	}
+	key, err := c.deriveKey(salt)
+	if err != nil {
+		return "", err
+	}
+	var data []byte
+	switch c.algorithm {
+	case Sm4Gcm:
+		data, err = utils.Sm4GcmDecrypt(key, sec)
+	default:
+		data, err = utils.AesGcmDecrypt(key, sec)
+	}
+	if err != nil {
+		return "", fmt.Errorf("凭据解密失败，主密钥错误")
+	}
+	return string(data), nil
+}
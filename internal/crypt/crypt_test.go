@@ -0,0 +1,53 @@
+package crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	for _, algorithm := range []string{AesGcm, Sm4Gcm} {
+		c, err := NewCipherWithKey(algorithm, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 重启后盐不同，仍可解密之前的密文
+		c2, _ := NewCipherWithKey(algorithm, "key")
+		other, _ := NewCipherWithKey(algorithm, "other")
+		for _, plain := range []string{"pwd", "enc:pwd", "enc:" + algorithm + ":pwd"} {
+			sec, err := c.Encrypt(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sec == plain || !IsEncrypted(sec) {
+				t.Errorf("%s Encrypt(%q) = %q, want cipher text", algorithm, plain, sec)
+			}
+			for _, d := range []*Cipher{c, c2} {
+				if got, err := d.Decrypt(sec); err != nil || got != plain {
+					t.Errorf("%s Decrypt() = %q, %v, want %q", algorithm, got, err, plain)
+				}
+			}
+			if _, err = other.Decrypt(sec); err == nil {
+				t.Errorf("%s Decrypt() with other key succeeded", algorithm)
+			}
+		}
+	}
+}
+
+func TestDecryptPlain(t *testing.T) {
+	c, _ := NewCipherWithKey(AesGcm, "key")
+	for _, plain := range []string{"", "pwd", "enc:pwd", "enc:aes-gcm:pwd"} {
+		if IsEncrypted(plain) {
+			t.Errorf("IsEncrypted(%q) = true", plain)
+		}
+		for _, d := range []*Cipher{c, nil} {
+			if got, err := d.Decrypt(plain); err != nil || got != plain {
+				t.Errorf("Decrypt(%q) = %q, %v", plain, got, err)
+			}
+		}
+	}
+	sec, _ := c.Encrypt("pwd")
+	if _, err := (*Cipher)(nil).Decrypt(sec); err == nil || !strings.Contains(err.Error(), "主密钥") {
+		t.Errorf("Decrypt() without key = %v, want error", err)
+	}
+}
@@ -6,6 +6,14 @@ type Config struct {
 	Logger     LoggerConfig     `yaml:"logger"`
 	Jwt        JwtConfig        `yaml:"jwt"`
 	Prometheus PrometheusConfig `yaml:"prometheus"`
+	Encrypt    EncryptConfig    `yaml:"encrypt"`
+}
+
+// EncryptConfig 存储库凭据加密，主密钥优先取环境变量 KUBACKUP_MASTER_KEY，其次 keyFile、key，均为空时不加密
+type EncryptConfig struct {
+	Algorithm string `yaml:"algorithm"` // aes-gcm、sm4-gcm，默认 aes-gcm
+	Key       string `yaml:"key"`       // 主密钥
+	KeyFile   string `yaml:"keyFile"`   // 主密钥文件
 }
 
 type PrometheusConfig struct {
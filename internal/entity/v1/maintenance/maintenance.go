@@ -0,0 +1,59 @@
+package maintenance
+
+import (
+	"github.com/kubackup/kubackup/internal/entity/v1/common"
+	"time"
+)
+
+// Maintenance 存储库定时维护，按 cron 表达式执行检查、清理或重建索引
+type Maintenance struct {
+	common.BaseModel `storm:"inline"`
+	Name             string `json:"name"`
+	RepositoryId     int    `json:"repositoryId"`
+	Type             int    `json:"type"` //维护类型，同操作记录类型：1 检查，2 重建索引，3 清理，6 复制快照
+	Status           int    `json:"status"`
+	ExecTimeCron     string `json:"execTimeCron"` //定时执行时间
+	SkipIfBusy       bool   `json:"skipIfBusy"`   //存储库有任务执行或排队时跳过本次维护，否则排队等待
+
+	// 检查参数
+	ReadData       bool   `json:"readData"`       //读取所有数据
+	ReadDataSubset string `json:"readDataSubset"` //读取部分数据，如 1/5、10%
+	CheckUnused    bool   `json:"checkUnused"`    //查找未使用的数据
+	WithCache      bool   `json:"withCache"`      //使用缓存
+	RotateBuckets  int    `json:"rotateBuckets"`  //轮换校验的分组数 N，大于0时每次依次读取 1/N、2/N…，如每日执行且 N=30 时每月读取全部数据
+	LastBucket     int    `json:"lastBucket"`     //最近一次校验通过的分组
+
+	// 清理参数
+	MaxUnused          string `json:"maxUnused"`          //允许保留的未使用空间，如 5%、1G，为空时默认 5%
+	MaxRepackSize      string `json:"maxRepackSize"`      //单次最大重新打包大小
+	RepackCachableOnly bool   `json:"repackCachableOnly"` //仅重新打包元数据
+
+	// 重建索引参数
+	ReadAllPacks bool `json:"readAllPacks"` //读取所有数据包重建索引
+
+	// 复制快照参数
+	TargetRepositoryId int    `json:"targetRepositoryId"` //目标存储库
+	Hosts              string `json:"hosts"`              //只复制这些主机的快照，多个以逗号分隔
+	Tags               string `json:"tags"`               //只复制包含这些标签的快照，同 restic --tag
+	Paths              string `json:"paths"`              //只复制包含这些路径的快照，多个以逗号分隔
+
+	LastRunTime     time.Time `json:"lastRunTime"`     //最近一次执行时间
+	LastOperationId int       `json:"lastOperationId"` //最近一次执行的操作记录
+}
+
+// 维护类型，与操作记录类型一致
+const (
+	TypeCheck        = 1
+	TypeRebuildIndex = 2
+	TypePrune        = 3
+	TypeCopy         = 6
+)
+
+// 维护计划状态
+const (
+	RunningStatus = 1
+	StopStatus    = 2
+)
+
+// DefaultMaxUnused 清理时默认允许保留的未使用空间
+const DefaultMaxUnused = "5%"
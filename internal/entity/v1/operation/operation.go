@@ -6,12 +6,14 @@ import (
 )
 
 type Operation struct {
-	common.BaseModel `storm:"inline"`
-	RepositoryId     int                  `json:"repositoryId"`
-	PolicyId         int                  `json:"policyId"`
-	Type             int                  `json:"type"`
-	Status           int                  `json:"status"`
-	Logs             []*wsTaskInfo.Sprint `json:"logs"`
+	common.BaseModel   `storm:"inline"`
+	RepositoryId       int                  `json:"repositoryId"`
+	PolicyId           int                  `json:"policyId"`
+	MaintenanceId      int                  `json:"maintenanceId"`      //定时维护触发时的维护计划
+	TargetRepositoryId int                  `json:"targetRepositoryId"` //复制快照的目标存储库
+	Type               int                  `json:"type"`
+	Status             int                  `json:"status"`
+	Logs               []*wsTaskInfo.Sprint `json:"logs"`
 }
 
 const (
@@ -20,4 +22,5 @@ const (
 	PRUNE_TYPE        = 3 // PRUNE 清理无用数据
 	FORGET_TYPE       = 4 // FORGET 清理过期快照
 	MIGRATE_TYPE      = 5 //MIGRATE
+	COPY_TYPE         = 6 // COPY 复制快照到其他存储库
 )
@@ -2,18 +2,104 @@ package plan
 
 import (
 	"github.com/kubackup/kubackup/internal/entity/v1/common"
+	"strings"
+	"time"
 )
 
 type Plan struct {
-	common.BaseModel `storm:"inline"`
-	Name             string `json:"name"`
-	Path             string `json:"path"` //备份路径或还原路径
-	RepositoryId     int    `json:"repositoryId"`
-	Status           int    `json:"status"`
-	ExecTimeCron     string `json:"execTimeCron"`    //定时执行时间
-	ReadConcurrency  uint   `json:"readConcurrency"` //读取并发数量，默认取cpu线程数
+	common.BaseModel        `storm:"inline"`
+	Name                    string    `json:"name"`
+	Path                    string    `json:"path"`          //备份路径或还原路径，多个路径以逗号分隔
+	Paths                   []string  `json:"paths"`         //备份路径列表
+	RepositoryId            int       `json:"repositoryId"`  //首个目标存储库，兼容旧数据及按存储库搜索
+	RepositoryIds           []int     `json:"repositoryIds"` //目标存储库列表，每个存储库单独创建备份任务
+	FanOut                  int       `json:"fanOut"`        //多个存储库的执行方式，为空时依次执行
+	Status                  int       `json:"status"`
+	ExecTimeCron            string    `json:"execTimeCron"`      //定时执行时间
+	ReadConcurrency         uint      `json:"readConcurrency"`   //读取并发数量，默认取cpu线程数
+	Excludes                []string  `json:"excludes"`          //排除规则
+	InsensitiveExcludes     []string  `json:"iExcludes"`         //排除规则，忽略大小写
+	ExcludeFiles            []string  `json:"excludeFiles"`      //从文件中读取排除规则
+	InsensitiveExcludeFiles []string  `json:"iExcludeFiles"`     //从文件中读取排除规则，忽略大小写
+	ExcludeIfPresent        []string  `json:"excludeIfPresent"`  //目录中存在该文件时排除该目录，格式 filename[:header]
+	ExcludeCaches           bool      `json:"excludeCaches"`     //排除包含 CACHEDIR.TAG 的目录
+	ExcludeLargerThan       string    `json:"excludeLargerThan"` //排除大于该大小的文件，如 100M
+	ExcludeOtherFS          bool      `json:"excludeOtherFS"`    //排除其他文件系统
+	Tags                    []string  `json:"tags"`              //快照标签
+	Host                    string    `json:"host"`              //快照主机名，为空时使用本机主机名
+	GroupBy                 string    `json:"groupBy"`           //查找父快照的分组方式：host,paths,tags，为空时默认 host,paths
+	PreHook                 string    `json:"preHook"`           //备份前执行的脚本
+	PostHook                string    `json:"postHook"`          //备份后执行的脚本，可通过环境变量获取备份结果
+	HookTimeout             int       `json:"hookTimeout"`       //脚本超时时间，单位秒，为空时默认600
+	PreHookAbort            bool      `json:"preHookAbort"`      //备份前脚本执行失败时终止备份
+	SourceType              int       `json:"sourceType"`        //备份来源，为空时为文件目录
+	SourceCommand           string    `json:"sourceCommand"`     //备份来源为命令时执行的命令，如 pg_dump，其标准输出作为备份内容
+	StdinFilename           string    `json:"stdinFilename"`     //命令输出在快照中的文件名，默认 stdin
+	Priority                int       `json:"priority"`          //存储库队列中的优先级，数值越大越先执行
+	CatchUp                 bool      `json:"catchUp"`           //服务停止期间错过的定时备份在启动后补执行一次
+	CatchUpWindow           int       `json:"catchUpWindow"`     //补执行的最大延迟，单位分钟，为空时默认1440
+	RetryMax                int       `json:"retryMax"`          //定时备份失败后的最大重试次数
+	RetryDelay              int       `json:"retryDelay"`        //首次重试等待时间，单位秒，为空时默认60
+	RetryBackoff            float64   `json:"retryBackoff"`      //重试等待时间的增长倍数，为空时默认2
+	LastScheduledTime       time.Time `json:"lastScheduledTime"` //最近一次定时触发时间
+	LastSuccessTime         time.Time `json:"lastSuccessTime"`   //最近一次备份成功时间
+	LastStatus              int       `json:"lastStatus"`        //最近一次执行结果，汇总所有目标存储库
+	LastRunTime             time.Time `json:"lastRunTime"`       //最近一次执行结束时间
 }
 
+// GetRepositoryIds 获取目标存储库列表，兼容仅有 RepositoryId 的旧数据
+func (p *Plan) GetRepositoryIds() []int {
+	ids := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, id := range p.RepositoryIds {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 && p.RepositoryId > 0 {
+		ids = append(ids, p.RepositoryId)
+	}
+	return ids
+}
+
+// GetPaths 获取备份路径列表，兼容仅有 Path 的旧数据
+func (p *Plan) GetPaths() []string {
+	if len(p.Paths) > 0 {
+		return p.Paths
+	}
+	paths := make([]string, 0)
+	for _, s := range strings.Split(p.Path, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			paths = append(paths, s)
+		}
+	}
+	return paths
+}
+
+// 备份来源
+const (
+	SourceTypeFile    = 1 //文件目录
+	SourceTypeCommand = 2 //命令输出
+)
+
+// DefaultStdinFilename 命令输出在快照中的默认文件名
+const DefaultStdinFilename = "stdin"
+
+// 多个存储库的执行方式
+const (
+	FanOutSequential = 1 //依次执行，前一个存储库结束后再执行下一个
+	FanOutParallel   = 2 //同时执行
+)
+
+// 最近一次执行结果
+const (
+	LastStatusSuccess = 1 //所有存储库备份成功
+	LastStatusPartial = 2 //部分存储库备份失败
+	LastStatusError   = 3 //所有存储库备份失败
+)
+
 // 计划/策略 状态
 const (
 	RunningStatus = 1
@@ -0,0 +1,18 @@
+package plan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetPaths(t *testing.T) {
+	p := Plan{Path: "/etc, /srv,,/home"}
+	want := []string{"/etc", "/srv", "/home"}
+	if got := p.GetPaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPaths() = %v, want %v", got, want)
+	}
+	p.Paths = []string{"/data"}
+	if got := p.GetPaths(); !reflect.DeepEqual(got, []string{"/data"}) {
+		t.Errorf("GetPaths() = %v, want [/data]", got)
+	}
+}
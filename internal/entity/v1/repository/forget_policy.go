@@ -2,15 +2,21 @@ package repository
 
 import (
 	"github.com/kubackup/kubackup/internal/entity/v1/common"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// ForgetPolicy 清理策略，所有保留规则在一次 forget 中按快照分组共同计算
 type ForgetPolicy struct {
 	common.BaseModel `storm:"inline"`
-	RepositoryId     int    `json:"repositoryId"`
-	Path             string `json:"path"` // 路径
-	Status           int    `json:"status"`
+	RepositoryId     int      `json:"repositoryId"`
+	Path             string   `json:"path"` // 路径，多个路径以逗号分隔
+	Host             string   `json:"host"` // 主机名，为空时为本机
+	Tags             []string `json:"tags"` // 标签，为空时不过滤
+	Status           int      `json:"status"`
 	/**
-	类型
+	类型，仅兼容旧版本的单一规则，新规则请使用下方字段
 	last
 	hourly
 	daily
@@ -21,4 +27,105 @@ type ForgetPolicy struct {
 	Type string `json:"type"`
 	// 值
 	Value int `json:"value"`
+
+	// 保留数量，-1 表示不限
+	Last    int `json:"last"`
+	Hourly  int `json:"hourly"`
+	Daily   int `json:"daily"`
+	Weekly  int `json:"weekly"`
+	Monthly int `json:"monthly"`
+	Yearly  int `json:"yearly"`
+	// 保留时长内的快照，格式如 1y2m3d4h
+	Within        string `json:"within"`
+	WithinHourly  string `json:"withinHourly"`
+	WithinDaily   string `json:"withinDaily"`
+	WithinWeekly  string `json:"withinWeekly"`
+	WithinMonthly string `json:"withinMonthly"`
+	WithinYearly  string `json:"withinYearly"`
+	// 保留带有标签的快照，每项为逗号分隔的标签组合
+	KeepTags []string `json:"keepTags"`
+	// 快照分组方式，如 host,paths,tags，为空时使用 host,paths
+	GroupBy string `json:"groupBy"`
+}
+
+// Key 存储库、路径、主机名、标签及分组方式相同的策略作用于同一批快照，执行时合并后 forget 一次
+func (p *ForgetPolicy) Key() string {
+	paths := make([]string, 0)
+	for _, s := range strings.Split(p.Path, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			paths = append(paths, s)
+		}
+	}
+	sort.Strings(paths)
+	tags := append([]string{}, p.Tags...)
+	sort.Strings(tags)
+	return strings.Join([]string{strconv.Itoa(p.RepositoryId), strings.Join(paths, ","), p.Host,
+		strings.Join(tags, ","), p.GroupBy}, "|")
+}
+
+// MergeForgetPolicies 合并同一批快照的多条策略，旧版本的单一规则转换为对应字段。
+// 保留数量取较大值（-1 不限优先），保留时长及分组方式取第一个非空值，保留标签取并集
+func MergeForgetPolicies(policies []ForgetPolicy) ForgetPolicy {
+	res := policies[0]
+	res.Type = ""
+	res.Value = 0
+	res.KeepTags = nil
+	keepTags := make(map[string]bool)
+	for _, p := range policies {
+		counts := map[string]*int{
+			"last":    &res.Last,
+			"hourly":  &res.Hourly,
+			"daily":   &res.Daily,
+			"weekly":  &res.Weekly,
+			"monthly": &res.Monthly,
+			"yearly":  &res.Yearly,
+		}
+		for t, v := range map[string]int{
+			"last":    p.Last,
+			"hourly":  p.Hourly,
+			"daily":   p.Daily,
+			"weekly":  p.Weekly,
+			"monthly": p.Monthly,
+			"yearly":  p.Yearly,
+		} {
+			if t == p.Type && v == 0 {
+				v = p.Value
+			}
+			*counts[t] = maxForgetCount(*counts[t], v)
+		}
+		for _, w := range []struct {
+			dst *string
+			src string
+		}{
+			{&res.Within, p.Within},
+			{&res.WithinHourly, p.WithinHourly},
+			{&res.WithinDaily, p.WithinDaily},
+			{&res.WithinWeekly, p.WithinWeekly},
+			{&res.WithinMonthly, p.WithinMonthly},
+			{&res.WithinYearly, p.WithinYearly},
+			{&res.GroupBy, p.GroupBy},
+		} {
+			if *w.dst == "" {
+				*w.dst = w.src
+			}
+		}
+		for _, t := range p.KeepTags {
+			if !keepTags[t] {
+				keepTags[t] = true
+				res.KeepTags = append(res.KeepTags, t)
+			}
+		}
+	}
+	return res
+}
+
+func maxForgetCount(a, b int) int {
+	if a == -1 || b == -1 {
+		return -1
+	}
+	if b > a {
+		return b
+	}
+	return a
 }
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeForgetPolicies(t *testing.T) {
+	policies := []ForgetPolicy{
+		{RepositoryId: 1, Path: "/etc,/srv", Type: "daily", Value: 7},
+		{RepositoryId: 1, Path: "/srv, /etc", Type: "weekly", Value: 4},
+		{RepositoryId: 1, Path: "/etc,/srv", Daily: 3, Yearly: -1, Within: "1y", KeepTags: []string{"keep"}},
+	}
+	for _, p := range policies[1:] {
+		if p.Key() != policies[0].Key() {
+			t.Fatalf("Key() = %q, want %q", p.Key(), policies[0].Key())
+		}
+	}
+	got := MergeForgetPolicies(policies)
+	if got.Type != "" || got.Value != 0 {
+		t.Errorf("legacy rule not cleared: %s %d", got.Type, got.Value)
+	}
+	if got.Daily != 7 || got.Weekly != 4 || got.Yearly != -1 || got.Last != 0 {
+		t.Errorf("counts = daily %d weekly %d yearly %d last %d", got.Daily, got.Weekly, got.Yearly, got.Last)
+	}
+	if got.Within != "1y" || !reflect.DeepEqual(got.KeepTags, []string{"keep"}) {
+		t.Errorf("within = %q keepTags = %v", got.Within, got.KeepTags)
+	}
+
+	other := ForgetPolicy{RepositoryId: 1, Path: "/etc,/srv", Host: "db"}
+	if other.Key() == policies[0].Key() {
+		t.Error("policies with different hosts have the same key")
+	}
+}
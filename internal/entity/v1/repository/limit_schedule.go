@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+// LimitSchedule 分时段限速，如 08:00-20:00 上传限速 10240 KiB/s
+type LimitSchedule struct {
+	Start         string `json:"start"`         //开始时间，格式 15:04
+	End           string `json:"end"`           //结束时间，格式 15:04，早于开始时间时表示跨天
+	UploadLimit   int    `json:"uploadLimit"`   //上传限速，单位 KiB/s，为空时不限速
+	DownloadLimit int    `json:"downloadLimit"` //下载限速，单位 KiB/s，为空时不限速
+}
+
+// parseClock 解析 15:04 格式的时间，返回当天的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误：%s", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate 校验时段格式
+func (s LimitSchedule) Validate() error {
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf("开始时间与结束时间不能相同：%s", s.Start)
+	}
+	if s.UploadLimit < 0 || s.DownloadLimit < 0 {
+		return fmt.Errorf("限速不能小于0")
+	}
+	return nil
+}
+
+// Contains 判断 t 是否处于该时段内，包含开始时间不包含结束时间
+func (s LimitSchedule) Contains(t time.Time) bool {
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// LimitsAt 获取 t 时刻的上传、下载限速，单位 KiB/s，0 表示不限速
+func (r *Repository) LimitsAt(t time.Time) (upload, download int) {
+	for _, s := range r.LimitSchedules {
+		if s.Contains(t) {
+			return s.UploadLimit, s.DownloadLimit
+		}
+	}
+	return r.UploadLimit, r.DownloadLimit
+}
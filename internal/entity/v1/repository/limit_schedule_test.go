@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimitsAt(t *testing.T) {
+	r := Repository{
+		UploadLimit:   100,
+		DownloadLimit: 200,
+		LimitSchedules: []LimitSchedule{
+			{Start: "08:00", End: "20:00", UploadLimit: 10240},
+			{Start: "22:00", End: "02:00", DownloadLimit: 50},
+		},
+	}
+	day := func(h, m int) time.Time {
+		return time.Date(2024, 1, 1, h, m, 0, 0, time.Local)
+	}
+	tests := []struct {
+		t        time.Time
+		upload   int
+		download int
+	}{
+		{day(7, 59), 100, 200},
+		{day(8, 0), 10240, 0},
+		{day(19, 59), 10240, 0},
+		{day(20, 0), 100, 200},
+		{day(23, 0), 0, 50},
+		{day(1, 30), 0, 50},
+		{day(2, 0), 100, 200},
+	}
+	for _, tt := range tests {
+		upload, download := r.LimitsAt(tt.t)
+		if upload != tt.upload || download != tt.download {
+			t.Errorf("LimitsAt(%s) = %d, %d, want %d, %d", tt.t.Format("15:04"), upload, download, tt.upload, tt.download)
+		}
+	}
+}
+
+func TestLimitScheduleValidate(t *testing.T) {
+	if err := (LimitSchedule{Start: "08:00", End: "20:00"}).Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (LimitSchedule{Start: "8点", End: "20:00"}).Validate(); err == nil {
+		t.Error("want error for invalid start")
+	}
+	if err := (LimitSchedule{Start: "08:00", End: "08:00"}).Validate(); err == nil {
+		t.Error("want error for empty range")
+	}
+}
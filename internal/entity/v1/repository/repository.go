@@ -10,18 +10,19 @@ type Repository struct {
 	Name             string `json:"name"`
 	Type             int    `json:"type"`
 	Endpoint         string `json:"endPoint"`
-	// AWS_DEFAULT_REGION
+	// AWS_DEFAULT_REGION，OS_REGION_NAME
 	Region string `json:"region"`
+	// 存储桶，Azure、Swift 为容器，可带路径，如 bucket/path
 	Bucket string `json:"bucket"`
-	// AWS_ACCESS_KEY_ID
+	// AWS_ACCESS_KEY_ID，OS_USERNAME
 	KeyId string `json:"keyId"`
-	// AWS_SECRET_ACCESS_KEY
+	// AWS_SECRET_ACCESS_KEY，OS_PASSWORD
 	Secret string `json:"secret"`
-	// GOOGLE_PROJECT_ID
+	// GOOGLE_PROJECT_ID，OS_PROJECT_NAME
 	ProjectID string `json:"projectId"`
-	// AZURE_ACCOUNT_NAME
+	// AZURE_ACCOUNT_NAME，OS_USER_DOMAIN_NAME
 	AccountName string `json:"accountName"`
-	// AZURE_ACCOUNT_KEY，B2_ACCOUNT_KEY
+	// AZURE_ACCOUNT_KEY，B2_ACCOUNT_KEY，GCS 为服务器上的凭据文件路径，为空时使用默认凭据
 	AccountKey string `json:"accountKey"`
 	// B2_ACCOUNT_ID
 	AccountID string `json:"accountId"`
@@ -32,6 +33,11 @@ type Repository struct {
 	RepositoryVersion string `json:"repositoryVersion"`
 	Compression       int    `json:"compression"` //压缩模式auto:0、off:1、max:2
 	PackSize          int    `json:"packSize"`
+	MaxConcurrency    int    `json:"maxConcurrency"` //同时运行的备份任务数量，为空时默认2
+	UploadLimit       int    `json:"uploadLimit"`    //上传限速，单位 KiB/s，为空时不限速
+	DownloadLimit     int    `json:"downloadLimit"`  //下载限速，单位 KiB/s，为空时不限速
+	// 分时段限速，处于时段内时替代上面的限速
+	LimitSchedules []LimitSchedule `json:"limitSchedules"`
 }
 
 // Type
@@ -43,10 +49,19 @@ const (
 	Rest   = 5
 	HwObs  = 6
 	TxCos  = 7
+	Azure  = 8
+	Gcs    = 9
+	B2     = 10
+	Swift  = 11
+	Rclone = 12 //Endpoint 为 rclone 远程路径，如 remote:path
 )
 
 const (
 	StatusNone = 1
 	StatusRun  = 2
 	StatusErr  = 3
+	// StatusCancel 已取消，仅用于操作记录
+	StatusCancel = 4
+	// StatusSkip 已跳过，仅用于操作记录
+	StatusSkip = 5
 )
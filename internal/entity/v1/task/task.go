@@ -7,17 +7,25 @@ import (
 
 type Task struct {
 	common.BaseModel `storm:"inline"`
-	Name             string               `json:"name"`
-	Path             string               `json:"path"`   //备份路径或还原路径
-	PlanId           int                  `json:"planId"` //计划id
-	RepositoryId     int                  `json:"repositoryId"`
-	Status           int                  `json:"status"`
-	ParentId         string               `json:"parentId"`      //父快照id
-	Scanner          *model.VerboseUpdate `json:"scanner"`       //扫描结果
-	ScannerError     *model.ErrorUpdate   `json:"scannerError"`  //扫描错误
-	ArchivalError    []model.ErrorUpdate  `json:"archivalError"` //备份错误
-	Summary          *model.SummaryOutput `json:"summary"`       //备份结果
-	Progress         *model.StatusUpdate  `json:"progress"`      //当前进度
-	RestoreError     []model.ErrorUpdate  `json:"restoreError"`  //恢复错误
-	ReadConcurrency  uint                 //读取并发数量，默认2
+	Name             string                   `json:"name"`
+	Path             string                   `json:"path"`   //备份路径或还原路径
+	PlanId           int                      `json:"planId"` //计划id
+	RepositoryId     int                      `json:"repositoryId"`
+	Status           int                      `json:"status"`
+	ParentId         string                   `json:"parentId"`      //父快照id
+	Scanner          *model.VerboseUpdate     `json:"scanner"`       //扫描结果
+	ScannerError     *model.ErrorUpdate       `json:"scannerError"`  //扫描错误
+	ArchivalError    []model.ErrorUpdate      `json:"archivalError"` //备份错误
+	Summary          *model.SummaryOutput     `json:"summary"`       //备份结果
+	Progress         *model.StatusUpdate      `json:"progress"`      //当前进度
+	RestoreError     []model.ErrorUpdate      `json:"restoreError"`  //恢复错误
+	HookLogs         []model.HookUpdate       `json:"hookLogs"`      //备份前后脚本及备份命令输出
+	RestoreLogs      []model.RestoreUpdate    `json:"restoreLogs"`   //还原时对已有文件的处理记录
+	RetryOf          int                      `json:"retryOf"`       //重试的原始任务id
+	SnapshotId       string                   `json:"snapshotId"`    //还原的快照id
+	Restore          *model.RestoreInfo       `json:"restore"`       //还原参数，用于中断后继续还原
+	ResumeOf         int                      `json:"resumeOf"`      //继续还原的原始任务id
+	Checkpoint       *model.RestoreCheckpoint `json:"checkpoint"`    //还原进度，继续还原时跳过已完成的文件
+	Attempt          int                      `json:"attempt"`       //重试次数，首次执行为0
+	ReadConcurrency  uint                     //读取并发数量，默认2
 }
@@ -0,0 +1,8 @@
+package model
+
+// KeyInfo 添加或更换存储库密钥的参数
+type KeyInfo struct {
+	Password string `json:"password"`
+	UserName string `json:"userName"` //为空时使用运行 kubackup 的用户
+	HostName string `json:"hostName"` //为空时使用本机主机名
+}
@@ -1,13 +1,23 @@
 package model
 
 type RestoreInfo struct {
-	Exclude  string `json:"exclude"`
-	IExclude string `json:"iExclude"`
-	Include  string `json:"include"`
-	IInclude string `json:"iInclude"`
-	Target   string `json:"target"`
-	Hosts    string `json:"hosts"`
-	Paths    string `json:"paths"`
-	Tags     string `json:"tags"`
-	Verify   bool   `json:"verify"`
+	Exclude   string `json:"exclude"`
+	IExclude  string `json:"iExclude"`
+	Include   string `json:"include"`
+	IInclude  string `json:"iInclude"`
+	Target    string `json:"target"`
+	Hosts     string `json:"hosts"`
+	Paths     string `json:"paths"`
+	Tags      string `json:"tags"`
+	Verify    bool   `json:"verify"`
+	Overwrite string `json:"overwrite"` //已有文件的处理方式：always、if-changed、if-newer、never，默认 always
+	Delete    bool   `json:"delete"`    //删除目标目录中快照里不存在的文件
+	Sparse    bool   `json:"sparse"`    //以稀疏文件方式还原
+	DryRun    bool   `json:"dryRun"`    //仅返回将写入及覆盖的文件和所需空间，不执行还原
+}
+
+// RestoreCheckpoint 还原进度，用于中断后继续还原时跳过已完成的文件
+type RestoreCheckpoint struct {
+	ContentDone bool   `json:"contentDone"` //文件内容已全部写入，正在恢复元数据
+	Cursor      string `json:"cursor"`      //恢复元数据时处理到的位置，之前的文件已完成
 }
@@ -50,5 +50,24 @@ type SummaryOutput struct {
 	TotalBytesProcessed string `json:"totalBytes_processed"` // 文件总大小
 	TotalDuration       string `json:"totalDuration"`        // 总耗时 in seconds
 	SnapshotID          string `json:"snapshotId"`
-	DryRun              bool   `json:"dryRun,omitempty"` //
+	DryRun              bool   `json:"dryRun,omitempty"`       //
+	FilesDeleted        uint   `json:"filesDeleted,omitempty"` //还原时删除的多余文件数
+}
+
+// HookUpdate 备份前后脚本及备份命令输出
+type HookUpdate struct {
+	MessageType string `json:"messageType"` // "hook"
+	Hook        string `json:"hook"`        // pre/post/command
+	Text        string `json:"text"`        // 输出内容
+	Level       int    `json:"level"`       // 日志级别，同 wsTaskInfo
+	Time        string `json:"time"`
+}
+
+// RestoreUpdate 还原时对目标目录已有文件的处理
+type RestoreUpdate struct {
+	MessageType string `json:"messageType"` // "restore"
+	Action      string `json:"action"`      // overwrite/skip/delete
+	Item        string `json:"item"`        // 快照中的路径
+	Reason      string `json:"reason"`      // 处理原因
+	Time        string `json:"time"`
 }
@@ -6,8 +6,11 @@ import (
 	"github.com/kubackup/kubackup/internal/api"
 	v1 "github.com/kubackup/kubackup/internal/api/v1"
 	"github.com/kubackup/kubackup/internal/cron"
+	"github.com/kubackup/kubackup/internal/crypt"
 	"github.com/kubackup/kubackup/internal/i18n"
 	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"github.com/kubackup/kubackup/internal/service/v1/repository"
 	"github.com/kubackup/kubackup/internal/service/v1/user"
 	"github.com/kubackup/kubackup/pkg/utils"
 	"github.com/kubackup/kubackup/restic_proxy"
@@ -21,10 +24,12 @@ func InitRoute(party iris.Party) {
 	
 	apiParty := party.Party("/api")
 	api.AddPingRoute(apiParty)
+	api.AddWebdavRoute(apiParty)
 	v1.AddV1Route(apiParty)
 	ininPrint()
 }
 func initOthers() {
+	initCrypt()
 	go resticProxy.InitRepository()
 	initAdmin()
 	utils.InitJwt()
@@ -44,6 +49,27 @@ func ininPrint() {
 		"api/ping")
 }
 
+// initCrypt 读取主密钥，并加密数据库中的明文凭据
+func initCrypt() {
+	c, err := crypt.NewCipher(server.Config().Encrypt)
+	if err != nil {
+		panic(err)
+	}
+	crypt.SetDefault(c)
+	if c == nil {
+		fmt.Println("未配置主密钥，存储库凭据以明文保存")
+		return
+	}
+	count, err := repository.GetService().EncryptCredentials(c, c, common.DBOptions{})
+	if err != nil {
+		fmt.Println("存储库凭据加密失败：", err.Error())
+		return
+	}
+	if count > 0 {
+		fmt.Printf("已加密%d个存储库的凭据\n", count)
+	}
+}
+
 // initAdmin 初始化admin账号
 func initAdmin() {
 	userServer := user.GetService()
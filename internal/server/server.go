@@ -208,6 +208,9 @@ func (e *BackupServer) setResultHandler() {
 		if strings.HasPrefix(p, "/metrics") {
 			return
 		}
+		if strings.HasPrefix(p, "/api/webdav") {
+			return
+		}
 		ss := strings.Split(p, "/")
 		if len(ss) >= 3 {
 			for i := range ss {
@@ -0,0 +1,100 @@
+package maintenance
+
+import (
+	"github.com/asdine/storm/v3/q"
+	"github.com/kubackup/kubackup/internal/entity/v1/maintenance"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"time"
+)
+
+type Service interface {
+	common.DBService
+	Create(m *maintenance.Maintenance, options common.DBOptions) error
+	List(repoId, status int, options common.DBOptions) ([]maintenance.Maintenance, error)
+	Get(id int, options common.DBOptions) (*maintenance.Maintenance, error)
+	Delete(id int, options common.DBOptions) error
+	DeleteByRepo(repoId int, options common.DBOptions) error
+	Update(m *maintenance.Maintenance, options common.DBOptions) error
+	UpdateField(id int, fieldName string, value interface{}, options common.DBOptions) error
+}
+
+func GetService() Service {
+	return &Maintenance{
+		DefaultDBService: common.DefaultDBService{},
+	}
+}
+
+type Maintenance struct {
+	common.DefaultDBService
+}
+
+func (m Maintenance) Create(mt *maintenance.Maintenance, options common.DBOptions) error {
+	db := m.GetDB(options)
+	mt.CreatedAt = time.Now()
+	return db.Save(mt)
+}
+
+func (m Maintenance) List(repoId, status int, options common.DBOptions) ([]maintenance.Maintenance, error) {
+	db := m.GetDB(options)
+	var ms []q.Matcher
+	if repoId > 0 {
+		ms = append(ms, q.Eq("RepositoryId", repoId))
+	}
+	if status > 0 {
+		ms = append(ms, q.Eq("Status", status))
+	}
+	query := db.Select(q.And(ms...)).OrderBy("CreatedAt").Reverse()
+	res := make([]maintenance.Maintenance, 0)
+	if err := query.Find(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (m Maintenance) Get(id int, options common.DBOptions) (*maintenance.Maintenance, error) {
+	db := m.GetDB(options)
+	var mt maintenance.Maintenance
+	err := db.One("Id", id, &mt)
+	if err != nil {
+		return nil, err
+	}
+	return &mt, nil
+}
+
+func (m Maintenance) Delete(id int, options common.DBOptions) error {
+	db := m.GetDB(options)
+	mt, err := m.Get(id, options)
+	if err != nil {
+		return err
+	}
+	return db.DeleteStruct(mt)
+}
+
+func (m Maintenance) DeleteByRepo(repoId int, options common.DBOptions) error {
+	db := m.GetDB(options)
+	mts, err := m.List(repoId, 0, options)
+	if err != nil {
+		return err
+	}
+	for i := range mts {
+		err = db.DeleteStruct(&mts[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m Maintenance) Update(mt *maintenance.Maintenance, options common.DBOptions) error {
+	db := m.GetDB(options)
+	mt.UpdatedAt = time.Now()
+	return db.Update(mt)
+}
+
+func (m Maintenance) UpdateField(id int, fieldName string, value interface{}, options common.DBOptions) error {
+	db := m.GetDB(options)
+	th := &maintenance.Maintenance{}
+	th.Id = id
+	th.UpdatedAt = time.Now()
+	return db.UpdateField(th, fieldName, value)
+}
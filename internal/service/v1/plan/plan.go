@@ -37,7 +37,7 @@ func (p Plan) Search(num, size, status, RepositoryId int, path, name string, opt
 		ms = append(ms, q.Eq("Status", status))
 	}
 	if RepositoryId > 0 {
-		ms = append(ms, q.Eq("RepositoryId", RepositoryId))
+		ms = append(ms, q.Or(q.Eq("RepositoryId", RepositoryId), storm.Contains("RepositoryIds", RepositoryId)))
 	}
 	if path != "" {
 		ms = append(ms, storm.Like("Path", path))
@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"fmt"
 	"github.com/asdine/storm/v3/q"
+	"github.com/kubackup/kubackup/internal/crypt"
 	"github.com/kubackup/kubackup/internal/entity/v1/repository"
 	"github.com/kubackup/kubackup/internal/service/v1/common"
 	"github.com/kubackup/kubackup/pkg/storm"
@@ -16,6 +18,48 @@ type Service interface {
 	Delete(id int, options common.DBOptions) error
 	Update(repository *repository.Repository, options common.DBOptions) error
 	UpdateField(id int, fieldName string, value interface{}, options common.DBOptions) error
+	EncryptCredentials(from, to *crypt.Cipher, options common.DBOptions) (int, error)
+}
+
+// credentialFields 加密保存的凭据字段
+var credentialFields = []string{"Password", "Secret", "AccountKey", "KeyId"}
+
+func credentials(rep *repository.Repository) []*string {
+	return []*string{&rep.Password, &rep.Secret, &rep.AccountKey, &rep.KeyId}
+}
+
+// encrypt 加密凭据字段，返回恢复明文的函数
+func encrypt(rep *repository.Repository) (func(), error) {
+	fields := credentials(rep)
+	plain := make([]string, len(fields))
+	for i, f := range fields {
+		plain[i] = *f
+	}
+	restore := func() {
+		for i, f := range fields {
+			*f = plain[i]
+		}
+	}
+	for _, f := range fields {
+		v, err := crypt.Default().Encrypt(*f)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		*f = v
+	}
+	return restore, nil
+}
+
+func decrypt(rep *repository.Repository) error {
+	for _, f := range credentials(rep) {
+		v, err := crypt.Default().Decrypt(*f)
+		if err != nil {
+			return fmt.Errorf("存储库%s：%v", rep.Name, err)
+		}
+		*f = v
+	}
+	return nil
 }
 
 func GetService() Service {
@@ -33,9 +77,71 @@ func (c *Repository) UpdateField(id int, fieldName string, value interface{}, op
 	th := &repository.Repository{}
 	th.Id = id
 	th.UpdatedAt = time.Now()
+	if v, ok := value.(string); ok && isCredentialField(fieldName) {
+		var err error
+		value, err = crypt.Default().Encrypt(v)
+		if err != nil {
+			return err
+		}
+	}
 	return db.UpdateField(th, fieldName, value)
 }
 
+func isCredentialField(fieldName string) bool {
+	for _, f := range credentialFields {
+		if f == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptCredentials 使用 from 解密、to 重新加密所有存储库的凭据，用于迁移明文数据及更换主密钥。
+// from 与 to 相同时只加密明文，to 为 nil 时保存为明文，返回修改的存储库数量
+func (c *Repository) EncryptCredentials(from, to *crypt.Cipher, options common.DBOptions) (int, error) {
+	db := c.GetDB(options)
+	tx, err := db.Begin(true)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	reps := make([]repository.Repository, 0)
+	if err = tx.All(&reps); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, rep := range reps {
+		changed := false
+		for i, f := range credentials(&rep) {
+			plain, err := from.Decrypt(*f)
+			if err != nil {
+				return 0, fmt.Errorf("存储库%s：%v", rep.Name, err)
+			}
+			// 已使用当前主密钥加密
+			if from == to && crypt.IsEncrypted(*f) {
+				continue
+			}
+			v, err := to.Encrypt(plain)
+			if err != nil {
+				return 0, err
+			}
+			if v == *f {
+				continue
+			}
+			th := &repository.Repository{}
+			th.Id = rep.Id
+			if err = tx.UpdateField(th, credentialFields[i], v); err != nil {
+				return 0, err
+			}
+			changed = true
+		}
+		if changed {
+			count++
+		}
+	}
+	return count, tx.Commit()
+}
+
 func (c *Repository) Get(id int, options common.DBOptions) (*repository.Repository, error) {
 	db := c.GetDB(options)
 	var rep repository.Repository
@@ -43,27 +149,42 @@ func (c *Repository) Get(id int, options common.DBOptions) (*repository.Reposito
 	if err != nil {
 		return nil, err
 	}
+	if err = decrypt(&rep); err != nil {
+		return nil, err
+	}
 	return &rep, nil
 }
 
 func (c *Repository) Update(repository *repository.Repository, options common.DBOptions) error {
 	db := c.GetDB(options)
 	repository.UpdatedAt = time.Now()
+	restore, err := encrypt(repository)
+	if err != nil {
+		return err
+	}
+	defer restore()
 	return db.Update(repository)
 }
 
 func (c *Repository) Delete(id int, options common.DBOptions) error {
 	db := c.GetDB(options)
-	rep, err := c.Get(id, options)
+	// 不解密凭据，主密钥错误时也可以删除
+	var rep repository.Repository
+	err := db.One("Id", id, &rep)
 	if err != nil {
 		return err
 	}
-	return db.DeleteStruct(rep)
+	return db.DeleteStruct(&rep)
 }
 
 func (c *Repository) Create(repository *repository.Repository, options common.DBOptions) error {
 	db := c.GetDB(options)
 	repository.CreatedAt = time.Now()
+	restore, err := encrypt(repository)
+	if err != nil {
+		return err
+	}
+	defer restore()
 	return db.Save(repository)
 }
 
@@ -81,5 +202,10 @@ func (c *Repository) List(repotype int, name string, options common.DBOptions) (
 	if err = query.Find(&repositorys); err != nil {
 		return
 	}
+	for i := range repositorys {
+		if err = decrypt(&repositorys[i]); err != nil {
+			return
+		}
+	}
 	return
 }
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"github.com/asdine/storm/v3"
+	"github.com/kubackup/kubackup/internal/crypt"
+	"github.com/kubackup/kubackup/internal/entity/v1/repository"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptCredentials(t *testing.T) {
+	db, err := storm.Open(filepath.Join(t.TempDir(), "kubackup.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	opts := common.DBOptions{DB: db}
+	s := GetService()
+	defer crypt.SetDefault(nil)
+
+	raw := func(id int) repository.Repository {
+		var rep repository.Repository
+		if err := db.One("Id", id, &rep); err != nil {
+			t.Fatal(err)
+		}
+		return rep
+	}
+	check := func(id int, c *crypt.Cipher) {
+		crypt.SetDefault(c)
+		rep, err := s.Get(id, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Password != "pwd" || rep.Secret != "secret" || rep.KeyId != "keyid" || rep.AccountKey != "" {
+			t.Errorf("Get() = %+v, want decrypted credentials", rep)
+		}
+	}
+
+	crypt.SetDefault(nil)
+	rep := &repository.Repository{Name: "test", Password: "pwd", Secret: "secret", KeyId: "keyid"}
+	if err = s.Create(rep, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := raw(rep.Id); got.Password != "pwd" {
+		t.Fatalf("Password = %q, want plain text", got.Password)
+	}
+
+	// 明文 -> 加密
+	c1, _ := crypt.NewCipherWithKey(crypt.AesGcm, "key1")
+	count, err := s.EncryptCredentials(c1, c1, opts)
+	if err != nil || count != 1 {
+		t.Fatalf("EncryptCredentials() = %d, %v, want 1", count, err)
+	}
+	enc1 := raw(rep.Id)
+	if !crypt.IsEncrypted(enc1.Password) || !crypt.IsEncrypted(enc1.Secret) || !crypt.IsEncrypted(enc1.KeyId) || enc1.AccountKey != "" {
+		t.Fatalf("credentials not encrypted: %+v", enc1)
+	}
+	check(rep.Id, c1)
+
+	// 已加密的数据不重复处理
+	count, err = s.EncryptCredentials(c1, c1, opts)
+	if err != nil || count != 0 {
+		t.Fatalf("EncryptCredentials() = %d, %v, want 0", count, err)
+	}
+
+	// 更换主密钥
+	c2, _ := crypt.NewCipherWithKey(crypt.Sm4Gcm, "key2")
+	count, err = s.EncryptCredentials(c1, c2, opts)
+	if err != nil || count != 1 {
+		t.Fatalf("EncryptCredentials() = %d, %v, want 1", count, err)
+	}
+	if raw(rep.Id).Password == enc1.Password {
+		t.Fatal("credentials not re-encrypted")
+	}
+	check(rep.Id, c2)
+	crypt.SetDefault(c1)
+	if _, err = s.Get(rep.Id, opts); err == nil {
+		t.Error("Get() with old key succeeded")
+	}
+
+	// 旧主密钥无法解密，数据不变
+	if _, err = s.EncryptCredentials(c1, nil, opts); err == nil {
+		t.Error("EncryptCredentials() with old key succeeded")
+	}
+
+	// 解密为明文
+	count, err = s.EncryptCredentials(c2, nil, opts)
+	if err != nil || count != 1 {
+		t.Fatalf("EncryptCredentials() = %d, %v, want 1", count, err)
+	}
+	if got := raw(rep.Id); got.Password != "pwd" || got.Secret != "secret" || got.KeyId != "keyid" {
+		t.Errorf("credentials not decrypted: %+v", got)
+	}
+	check(rep.Id, nil)
+}
@@ -14,6 +14,7 @@ type LogInfo struct {
 	id            int
 	bound         chan string
 	sockJSSession sockjs.Session
+	wsTaskInfo.Cancelable
 	wsTaskInfo.WsTaskInfo
 }
 
@@ -87,6 +88,17 @@ func (ti *LogMap) Close(id int, reason string, status uint32) {
 	delete(ti.TaskInfos, id)
 }
 
+// Cancel 取消进行中的任务，任务不存在或已取消时返回 false
+func (ti *LogMap) Cancel(id int) bool {
+	ti.Lock.Lock()
+	defer ti.Lock.Unlock()
+	t, ok := ti.TaskInfos[id].(*LogInfo)
+	if !ok {
+		return false
+	}
+	return t.Cancel()
+}
+
 // GetCount 获取进行中任务数量
 func (ti *LogMap) GetCount() int {
 	return len(ti.TaskInfos)
@@ -15,6 +15,8 @@ const (
 	StatusRunning = 1 //运行中
 	StatusEnd     = 2 //已完成
 	StatusError   = 3 //错误
+	StatusCancel  = 4 //已取消
+	StatusQueued  = 5 //排队中
 )
 
 var TaskInfos = &TaskMap{TaskInfos: make(map[int]wsTaskInfo.WsTaskInfo)}
@@ -23,12 +25,19 @@ type TaskInfo struct {
 	id            int
 	bound         chan string
 	sockJSSession sockjs.Session
+	done          chan struct{}
 	Name          string
 	Path          string
 	Progress      *model.StatusUpdate
+	wsTaskInfo.Cancelable
 	wsTaskInfo.WsTaskInfo
 }
 
+// SetDone 设置任务结束时关闭的通道，用于等待任务结束
+func (ti *TaskInfo) SetDone(done chan struct{}) {
+	ti.done = done
+}
+
 func (ti *TaskInfo) GetId() int {
 	return ti.id
 }
@@ -97,9 +106,23 @@ func (ti *TaskMap) Close(id int, reason string, status uint32) {
 	defer ti.Lock.Unlock()
 	ti.TaskInfos[id].CloseSockJSSession(reason, status)
 	ti.TaskInfos[id].CloseBound()
+	if t, ok := ti.TaskInfos[id].(*TaskInfo); ok && t.done != nil {
+		close(t.done)
+	}
 	delete(ti.TaskInfos, id)
 }
 
+// Cancel 取消进行中的任务，任务不存在或已取消时返回 false
+func (ti *TaskMap) Cancel(id int) bool {
+	ti.Lock.Lock()
+	defer ti.Lock.Unlock()
+	t, ok := ti.TaskInfos[id].(*TaskInfo)
+	if !ok {
+		return false
+	}
+	return t.Cancel()
+}
+
 // GetCount 获取进行中任务数量
 func (ti *TaskMap) GetCount() int {
 	return len(ti.TaskInfos)
@@ -0,0 +1,38 @@
+package wsTaskInfo
+
+import "context"
+
+// Cancelable 可取消的任务，嵌入到任务信息中使用
+type Cancelable struct {
+	cancel    context.CancelFunc
+	cancelled chan struct{}
+}
+
+// SetCancel 设置取消任务时调用的函数
+func (c *Cancelable) SetCancel(cancel context.CancelFunc) {
+	c.cancel = cancel
+	c.cancelled = make(chan struct{})
+}
+
+// Cancel 取消任务，不支持取消或已取消时返回 false
+func (c *Cancelable) Cancel() bool {
+	if c.cancel == nil || c.IsCancelled() {
+		return false
+	}
+	close(c.cancelled)
+	c.cancel()
+	return true
+}
+
+// IsCancelled 任务是否已被取消
+func (c *Cancelable) IsCancelled() bool {
+	if c.cancelled == nil {
+		return false
+	}
+	select {
+	case <-c.cancelled:
+		return true
+	default:
+		return false
+	}
+}
@@ -117,3 +117,25 @@ func ListDir(path string) ([]*model.FileInfo, error) {
 func GetFilePath(file string) string {
 	return filepath.Dir(file)
 }
+
+// FreeSpace 获取路径所在文件系统的可用空间，路径不存在时使用最近的已存在上级目录
+func FreeSpace(path string) (uint64, error) {
+	path = existParent(path)
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+func existParent(path string) string {
+	path = filepath.Clean(path)
+	for !Exist(path) {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return path
+}
@@ -7,6 +7,7 @@ import (
 	"bufio"
 	"github.com/kubackup/kubackup/internal/consts"
 	"github.com/kubackup/kubackup/internal/model"
+	"golang.org/x/sys/windows"
 	"io"
 	"io/ioutil"
 	"os"
@@ -123,3 +124,29 @@ func ListDir(path string) ([]*model.FileInfo, error) {
 func GetFilePath(file string) string {
 	return filepath.Dir(file)
 }
+
+// FreeSpace 获取路径所在磁盘的可用空间，路径不存在时使用最近的已存在上级目录
+func FreeSpace(path string) (uint64, error) {
+	path = existParent(path)
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free, total, totalFree uint64
+	if err = windows.GetDiskFreeSpaceEx(p, &free, &total, &totalFree); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
+
+func existParent(path string) string {
+	path = filepath.Clean(path)
+	for !Exist(path) {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return path
+}
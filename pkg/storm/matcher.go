@@ -24,3 +24,24 @@ func (l *like) MatchField(v interface{}) (bool, error) {
 func Like(fieldName string, val string) q.Matcher {
 	return q.NewFieldMatcher(fieldName, &like{val: val})
 }
+
+type contains struct {
+	val interface{}
+}
+
+func (c *contains) MatchField(v interface{}) (bool, error) {
+	refV := reflect.ValueOf(v)
+	if refV.Kind() == reflect.Slice {
+		for i := 0; i < refV.Len(); i++ {
+			if reflect.DeepEqual(refV.Index(i).Interface(), c.val) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Contains 切片字段中包含 val
+func Contains(fieldName string, val interface{}) q.Matcher {
+	return q.NewFieldMatcher(fieldName, &contains{val: val})
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"github.com/tjfoc/gmsm/sm4"
+	"io"
+)
+
+// AesGcmEncrypt AES-GCM 加密，key 长度为 16、24 或 32，随机 nonce 置于密文前
+func AesGcmEncrypt(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcmSeal(block, data)
+}
+
+// AesGcmDecrypt AES-GCM 解密
+func AesGcmDecrypt(key []byte, sec []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(block, sec)
+}
+
+// Sm4GcmEncrypt SM4-GCM 加密，key 长度为 16，随机 nonce 置于密文前
+func Sm4GcmEncrypt(key []byte, data []byte) ([]byte, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcmSeal(block, data)
+}
+
+// Sm4GcmDecrypt SM4-GCM 解密
+func Sm4GcmDecrypt(key []byte, sec []byte) ([]byte, error) {
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(block, sec)
+}
+
+func gcmSeal(block cipher.Block, data []byte) ([]byte, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func gcmOpen(block cipher.Block, sec []byte) ([]byte, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sec) < gcm.NonceSize() {
+		return nil, fmt.Errorf("密文长度错误")
+	}
+	nonce, data := sec[:gcm.NonceSize()], sec[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, nil)
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAesGcmEncrypt(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	enc, err := AesGcmEncrypt(key, []byte("123456"))
+	assert.NoError(t, err)
+	dec, err := AesGcmDecrypt(key, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456", string(dec))
+
+	_, err = AesGcmDecrypt([]byte("fedcba9876543210fedcba9876543210"), enc)
+	assert.Error(t, err)
+}
+
+func TestSm4GcmEncrypt(t *testing.T) {
+	key := []byte("123321jdieu37dud")
+	enc, err := Sm4GcmEncrypt(key, []byte("123456"))
+	assert.NoError(t, err)
+	dec, err := Sm4GcmDecrypt(key, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456", string(dec))
+
+	enc[len(enc)-1] ^= 1
+	_, err = Sm4GcmDecrypt(key, enc)
+	assert.Error(t, err)
+}
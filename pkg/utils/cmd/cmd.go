@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
 )
 
@@ -51,3 +54,53 @@ func handleErr(stdout, stderr bytes.Buffer, err error) (string, error) {
 	}
 	return errMsg, err
 }
+
+// ExecStream 执行命令，标准输出及错误输出按行回调 output，ctx 超时或取消时终止命令
+func ExecStream(ctx context.Context, cmdStr string, env []string, output func(line string, stderr bool)) error {
+	cmd := exec.CommandContext(ctx, "bash", "-c", cmdStr)
+	cmd.Env = append(os.Environ(), env...)
+	stdout := NewLineWriter(func(line string) { output(line, false) })
+	stderr := NewLineWriter(func(line string) { output(line, true) })
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// 命令被终止后，子进程可能仍持有输出管道，避免 Wait 一直阻塞
+	cmd.WaitDelay = 5 * time.Second
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("error.commandTimeout")
+	}
+	return err
+}
+
+// LineWriter 按行切分写入的内容并回调 output
+type LineWriter struct {
+	buf    []byte
+	output func(line string)
+}
+
+func NewLineWriter(output func(line string)) *LineWriter {
+	return &LineWriter{output: output}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.output(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush 输出剩余不足一行的内容
+func (w *LineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.output(strings.TrimRight(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
@@ -0,0 +1,140 @@
+package resticProxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubackup/kubackup/internal/consts"
+	thmodel "github.com/kubackup/kubackup/internal/entity/v1/task"
+	"github.com/kubackup/kubackup/internal/model"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"github.com/kubackup/kubackup/internal/store/task"
+	wsTaskInfo "github.com/kubackup/kubackup/internal/store/ws_task_info"
+	shell "github.com/kubackup/kubackup/pkg/utils/cmd"
+	"strconv"
+	"time"
+)
+
+const (
+	HookPre  = "pre"
+	HookPost = "post"
+	// HookCommand 备份来源命令的错误输出
+	HookCommand = "command"
+	// DefaultHookTimeout 脚本默认超时时间
+	DefaultHookTimeout = 10 * time.Minute
+	// maxHookLogs 单个任务最多保存的脚本输出行数
+	maxHookLogs = 1000
+)
+
+// BackupHook 备份前后执行的脚本
+type BackupHook struct {
+	Command      string        //脚本命令，使用 bash -c 执行
+	Timeout      time.Duration //超时时间，为空时使用 DefaultHookTimeout
+	AbortOnError bool          //执行失败时终止备份，仅对备份前脚本有效
+}
+
+// SetPostHook 设置备份完成后执行的脚本，在 Finish 中执行
+func (t *TaskProgress) SetPostHook(hook BackupHook) {
+	t.postHook = hook
+}
+
+// RunHook 执行脚本，输出实时发送到任务日志并保存到任务记录
+func (t *TaskProgress) RunHook(ctx context.Context, name string, hook BackupHook, ta *thmodel.Task) error {
+	if hook.Command == "" {
+		return nil
+	}
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	t.hookLog(name, wsTaskInfo.Info, hook.Command)
+	err := shell.ExecStream(ctx, hook.Command, hookEnv(ta), func(line string, stderr bool) {
+		level := wsTaskInfo.Info
+		if stderr {
+			level = wsTaskInfo.Warning
+		}
+		t.hookLog(name, level, line)
+	})
+	if err != nil {
+		t.hookLog(name, wsTaskInfo.Error, err.Error())
+	} else {
+		t.hookLog(name, wsTaskInfo.Success, "exit status 0")
+	}
+	_ = taskHistoryService.UpdateField(t.task.GetId(), "HookLogs", t.hookLogs, common.DBOptions{})
+	if err != nil {
+		return fmt.Errorf("%s hook: %v", name, err)
+	}
+	return nil
+}
+
+func (t *TaskProgress) hookLog(name string, level int, text string) {
+	t.hookLock.Lock()
+	defer t.hookLock.Unlock()
+	if len(t.hookLogs) >= maxHookLogs {
+		return
+	}
+	hookUpdate := model.HookUpdate{
+		MessageType: "hook",
+		Hook:        name,
+		Text:        text,
+		Level:       level,
+		Time:        time.Now().Format(consts.Custom),
+	}
+	t.print(&hookUpdate, true)
+	t.hookLogs = append(t.hookLogs, hookUpdate)
+}
+
+// hookError 记录备份前脚本执行失败，任务最终状态为失败
+func (t *TaskProgress) hookError(name string, err error) {
+	errorUpdate := model.ErrorUpdate{
+		MessageType: "error",
+		Error:       err.Error(),
+		During:      "hook",
+		Item:        name,
+	}
+	t.errors = append(t.errors, errorUpdate)
+	_ = taskHistoryService.UpdateField(t.task.GetId(), "ArchivalError", t.errors, common.DBOptions{})
+}
+
+// hookEnv 脚本环境变量，备份完成后包含备份结果
+func hookEnv(ta *thmodel.Task) []string {
+	if ta == nil {
+		return nil
+	}
+	status := "running"
+	switch ta.Status {
+	case task.StatusEnd:
+		status = "success"
+	case task.StatusError:
+		status = "error"
+	case task.StatusCancel:
+		status = "cancelled"
+	}
+	env := []string{
+		"KUBACKUP_TASK_ID=" + strconv.Itoa(ta.Id),
+		"KUBACKUP_PLAN_ID=" + strconv.Itoa(ta.PlanId),
+		"KUBACKUP_REPOSITORY_ID=" + strconv.Itoa(ta.RepositoryId),
+		"KUBACKUP_PATHS=" + ta.Path,
+		"KUBACKUP_STATUS=" + status,
+	}
+	if s := ta.Summary; s != nil {
+		env = append(env,
+			"KUBACKUP_SNAPSHOT_ID="+s.SnapshotID,
+			"KUBACKUP_FILES_NEW="+strconv.FormatUint(uint64(s.FilesNew), 10),
+			"KUBACKUP_FILES_CHANGED="+strconv.FormatUint(uint64(s.FilesChanged), 10),
+			"KUBACKUP_FILES_UNMODIFIED="+strconv.FormatUint(uint64(s.FilesUnmodified), 10),
+			"KUBACKUP_DIRS_NEW="+strconv.FormatUint(uint64(s.DirsNew), 10),
+			"KUBACKUP_DIRS_CHANGED="+strconv.FormatUint(uint64(s.DirsChanged), 10),
+			"KUBACKUP_DIRS_UNMODIFIED="+strconv.FormatUint(uint64(s.DirsUnmodified), 10),
+			"KUBACKUP_DATA_BLOBS="+strconv.Itoa(s.DataBlobs),
+			"KUBACKUP_TREE_BLOBS="+strconv.Itoa(s.TreeBlobs),
+			"KUBACKUP_DATA_ADDED="+s.DataAdded,
+			"KUBACKUP_TOTAL_FILES_PROCESSED="+strconv.FormatUint(uint64(s.TotalFilesProcessed), 10),
+			"KUBACKUP_TOTAL_BYTES_PROCESSED="+s.TotalBytesProcessed,
+			"KUBACKUP_TOTAL_DURATION="+s.TotalDuration,
+			"KUBACKUP_DRY_RUN="+strconv.FormatBool(s.DryRun),
+		)
+	}
+	return env
+}
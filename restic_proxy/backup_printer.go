@@ -1,6 +1,7 @@
 package resticProxy
 
 import (
+	"context"
 	"github.com/kubackup/kubackup/internal/model"
 	"github.com/kubackup/kubackup/internal/server"
 	"github.com/kubackup/kubackup/internal/service/v1/common"
@@ -13,6 +14,7 @@ import (
 	"github.com/kubackup/kubackup/pkg/utils"
 	"math"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -24,6 +26,9 @@ type TaskProgress struct {
 	lastUpdate     time.Time
 	errors         []model.ErrorUpdate
 	minUpdatePause time.Duration
+	postHook       BackupHook //备份完成后执行的脚本
+	hookLogs       []model.HookUpdate
+	hookLock       sync.Mutex
 }
 
 func (t *TaskProgress) E(msg string, args ...interface{}) {
@@ -55,6 +60,7 @@ func NewTaskProgress(task wsTaskInfo.WsTaskInfo, minUpdatePause time.Duration) *
 	return &TaskProgress{
 		task:           task,
 		errors:         make([]model.ErrorUpdate, 0),
+		hookLogs:       make([]model.HookUpdate, 0),
 		weightCount:    1,
 		weightSize:     1,
 		minUpdatePause: minUpdatePause,
@@ -218,6 +224,11 @@ func (t *TaskProgress) ReportTotal(start time.Time, s archiver.ScanStats) {
 	}
 }
 func (t *TaskProgress) Finish(snapshotID restic.ID, start time.Time, summary *backup.Summary, dryRun bool) {
+	cancelled := t.task.(*task.TaskInfo).IsCancelled()
+	if cancelled {
+		// 已取消的任务没有生成快照
+		summary = nil
+	}
 	var summaryOut *model.SummaryOutput
 	var p1 *model.StatusUpdate
 	if summary != nil {
@@ -258,9 +269,19 @@ func (t *TaskProgress) Finish(snapshotID restic.ID, start time.Time, summary *ba
 	if taskhis.ScannerError != nil || len(taskhis.ArchivalError) > 0 {
 		status = task.StatusError
 	}
+	if cancelled {
+		status = task.StatusCancel
+	}
 	taskhis.Status = status
 	taskhis.Summary = summaryOut
 	taskhis.Progress = p1
+	// 备份后脚本执行失败只记录到脚本日志，不影响备份结果
+	if err := t.RunHook(context.Background(), HookPost, t.postHook, taskhis); err != nil {
+		server.Logger().Warnf("task %d: %v", t.task.GetId(), err)
+	}
+	if len(t.hookLogs) > 0 {
+		taskhis.HookLogs = t.hookLogs
+	}
 	_ = taskHistoryService.Update(taskhis, common.DBOptions{})
 	task.TaskInfos.Close(t.task.GetId(), "process end", 1)
 	go GetAllRepoStats()
@@ -0,0 +1,86 @@
+package resticProxy
+
+import (
+	"context"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	shell "github.com/kubackup/kubackup/pkg/utils/cmd"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// CommandReader 执行命令并读取其标准输出，首次读取时才启动命令。
+// 命令以非零状态退出时 Read 返回错误，备份失败
+type CommandReader struct {
+	ctx     context.Context
+	command string
+	stderr  *shell.LineWriter
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+
+	startOnce sync.Once
+	startErr  error
+	waitOnce  sync.Once
+	waitErr   error
+}
+
+// NewCommandReader 创建命令读取器，stderr 按行回调
+func NewCommandReader(ctx context.Context, command string, stderr func(line string)) *CommandReader {
+	return &CommandReader{
+		ctx:     ctx,
+		command: command,
+		stderr:  shell.NewLineWriter(stderr),
+	}
+}
+
+func (r *CommandReader) start() error {
+	r.startOnce.Do(func() {
+		cmd := exec.CommandContext(r.ctx, "bash", "-c", r.command)
+		cmd.Stderr = r.stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			r.startErr = err
+			return
+		}
+		if err = cmd.Start(); err != nil {
+			r.startErr = errors.Fatalf("command %q failed to start: %v", r.command, err)
+			return
+		}
+		r.cmd = cmd
+		r.stdout = stdout
+	})
+	return r.startErr
+}
+
+func (r *CommandReader) wait() error {
+	r.waitOnce.Do(func() {
+		err := r.cmd.Wait()
+		r.stderr.Flush()
+		if err != nil {
+			r.waitErr = errors.Fatalf("command %q failed: %v", r.command, err)
+		}
+	})
+	return r.waitErr
+}
+
+func (r *CommandReader) Read(p []byte) (int, error) {
+	if err := r.start(); err != nil {
+		return 0, err
+	}
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		// 输出结束后检查命令退出状态
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *CommandReader) Close() error {
+	if r.cmd == nil {
+		return nil
+	}
+	_ = r.stdout.Close()
+	return r.wait()
+}
@@ -3,6 +3,7 @@ package resticProxy
 import (
 	"context"
 	"fmt"
+	"github.com/kubackup/kubackup/internal/backend/gcs"
 	"github.com/kubackup/kubackup/internal/backend/hwobs"
 	"github.com/kubackup/kubackup/internal/backend/txcos"
 	"github.com/kubackup/kubackup/internal/consts/system_status"
@@ -13,7 +14,6 @@ import (
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/azure"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/b2"
-	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/gs"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/limiter"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/local"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/location"
@@ -32,6 +32,7 @@ import (
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
 	"net/url"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -62,6 +63,7 @@ type GlobalOptions struct {
 
 	backend.TransportOptions
 	limiter.Limits
+	dynLimiter *dynamicLimiter
 
 	// AWS_ACCESS_KEY_ID
 	KeyId string
@@ -77,7 +79,9 @@ type GlobalOptions struct {
 	AccountKey string
 	// B2_ACCOUNT_ID
 	AccountID string
-	password  string
+	// OS_AUTH_URL
+	AuthURL  string
+	password string
 
 	backends                              *location.Registry
 	backendTestHook, backendInnerTestHook backendWrapper
@@ -122,6 +126,16 @@ func GetGlobalOptions(rep repoModel.Repository) (GlobalOptions, context.CancelFu
 		types = "obs:"
 	case repoModel.TxCos:
 		types = "cos:"
+	case repoModel.Azure:
+		types = "azure:"
+	case repoModel.Gcs:
+		types = "gs:"
+	case repoModel.B2:
+		types = "b2:"
+	case repoModel.Swift:
+		types = "swift:"
+	case repoModel.Rclone:
+		types = "rclone:"
 	case repoModel.Local:
 		types = ""
 	default:
@@ -135,6 +149,10 @@ func GetGlobalOptions(rep repoModel.Repository) (GlobalOptions, context.CancelFu
 			return GlobalOptions{}, nil
 		}
 		repo = types + endpoint.Scheme + "://" + rep.KeyId + ":" + rep.Secret + "@" + endpoint.Host + endpoint.Path
+	} else if rep.Type == repoModel.Rclone {
+		repo = types + rep.Endpoint
+	} else if rep.Type == repoModel.Azure || rep.Type == repoModel.Gcs || rep.Type == repoModel.B2 || rep.Type == repoModel.Swift {
+		repo = types + containerLocation(rep.Bucket)
 	} else {
 		repo = types + rep.Endpoint + "/" + rep.Bucket
 	}
@@ -155,15 +173,18 @@ func GetGlobalOptions(rep repoModel.Repository) (GlobalOptions, context.CancelFu
 		AccountKey:        rep.AccountKey,
 		AccountID:         rep.AccountID,
 		password:          rep.Password,
+		AuthURL:           rep.Endpoint,
 		RepositoryVersion: rep.RepositoryVersion,
 		CacheDir:          server.Config().Data.CacheDir,
 		NoCache:           server.Config().Data.NoCache,
 		Options:           []string{},
 	}
+	globalOptions.Limits = repositoryLimits(rep, time.Now())
+	globalOptions.dynLimiter = newDynamicLimiter(globalOptions.Limits)
 	backends := location.NewRegistry()
 	backends.Register(azure.NewFactory())
 	backends.Register(b2.NewFactory())
-	backends.Register(gs.NewFactory())
+	backends.Register(gcs.NewFactory())
 	backends.Register(local.NewFactory())
 	backends.Register(rclone.NewFactory())
 	backends.Register(rest.NewFactory())
@@ -248,9 +269,22 @@ func InitRepository() {
 		Myrepositorys.Set(rep.Id, repoa)
 	}
 	go GetAllRepoStats()
+	repositoryLoadedOnce.Do(func() {
+		close(repositoryLoaded)
+	})
 	fmt.Println("仓库加载完毕！")
 }
 
+var (
+	repositoryLoaded     = make(chan struct{})
+	repositoryLoadedOnce sync.Once
+)
+
+// WaitRepositoryLoaded 等待服务启动后首次加载存储库完成
+func WaitRepositoryLoaded() {
+	<-repositoryLoaded
+}
+
 // GetRepository 获取仓库操作对象
 func GetRepository(repoid int) (*Repository, error) {
 	if repoid <= 0 {
@@ -423,10 +457,11 @@ func parseConfig(loc location.Location, gopts GlobalOptions, opts options.Option
 		return cfg, nil
 
 	case "gs":
-		cfg := loc.Config.(*gs.Config)
+		cfg := loc.Config.(*gcs.Config)
 		if cfg.ProjectID == "" {
 			cfg.ProjectID = gopts.ProjectID
 		}
+		cfg.CredentialsFile = gopts.AccountKey
 
 		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
 			return nil, err
@@ -445,6 +480,10 @@ func parseConfig(loc location.Location, gopts GlobalOptions, opts options.Option
 			cfg.AccountKey = options.NewSecretString(gopts.AccountKey)
 		}
 
+		if cfg.AccountName == "" {
+			return nil, errors.Fatalf("unable to open Azure backend: Account name (AccountName) is empty")
+		}
+
 		if err := opts.Apply(loc.Scheme, cfg); err != nil {
 			return nil, err
 		}
@@ -454,6 +493,31 @@ func parseConfig(loc location.Location, gopts GlobalOptions, opts options.Option
 
 	case "swift":
 		cfg := loc.Config.(*swift.Config)
+		if cfg.AuthURL == "" {
+			cfg.AuthURL = gopts.AuthURL
+		}
+		if cfg.UserName == "" {
+			cfg.UserName = gopts.KeyId
+		}
+		if cfg.APIKey.String() == "" {
+			cfg.APIKey = options.NewSecretString(gopts.Secret)
+		}
+		if cfg.Region == "" {
+			cfg.Region = gopts.Region
+		}
+		if cfg.Tenant == "" {
+			cfg.Tenant = gopts.ProjectID
+		}
+		if cfg.Domain == "" {
+			cfg.Domain = gopts.AccountName
+		}
+		if cfg.TenantDomain == "" {
+			cfg.TenantDomain = gopts.AccountName
+		}
+
+		if cfg.AuthURL == "" {
+			return nil, errors.Fatalf("unable to open Swift backend: auth URL (Endpoint) is empty")
+		}
 
 		if err := opts.Apply(loc.Scheme, cfg); err != nil {
 			return nil, err
@@ -558,6 +622,13 @@ func parseConfig(loc location.Location, gopts GlobalOptions, opts options.Option
 	return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
 }
 
+// containerLocation 将 bucket/path 转换为 restic 的 bucket:/path 格式
+func containerLocation(bucket string) string {
+	bucket = strings.Trim(bucket, "/")
+	container, prefix, _ := strings.Cut(bucket, "/")
+	return container + ":/" + prefix
+}
+
 // Open the backend specified by a location config.
 func open(ctx context.Context, s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
 	debug.Log("parsing location %v", location.StripPassword(gopts.backends, s))
@@ -579,7 +650,12 @@ func open(ctx context.Context, s string, gopts GlobalOptions, opts options.Optio
 		return nil, errors.Fatal(err.Error())
 	}
 
-	lim := limiter.NewStaticLimiter(gopts.Limits)
+	var lim limiter.Limiter
+	if gopts.dynLimiter != nil {
+		lim = gopts.dynLimiter
+	} else {
+		lim = limiter.NewStaticLimiter(gopts.Limits)
+	}
 	rt = lim.Transport(rt)
 
 	factory := gopts.backends.Lookup(loc.Scheme)
@@ -0,0 +1,170 @@
+package resticProxy
+
+import (
+	"context"
+	repoModel "github.com/kubackup/kubackup/internal/entity/v1/repository"
+	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend/limiter"
+	"golang.org/x/time/rate"
+	"io"
+	"net/http"
+	"time"
+)
+
+// dynamicLimiter 可在运行时调整速率的限速器，存储库打开后修改限速无需重新加载
+type dynamicLimiter struct {
+	upstream   *rate.Limiter
+	downstream *rate.Limiter
+}
+
+var _ limiter.Limiter = (*dynamicLimiter)(nil)
+
+func newDynamicLimiter(l limiter.Limits) *dynamicLimiter {
+	d := &dynamicLimiter{
+		upstream:   rate.NewLimiter(rate.Inf, 0),
+		downstream: rate.NewLimiter(rate.Inf, 0),
+	}
+	d.SetLimits(l)
+	return d
+}
+
+// SetLimits 修改限速，单位 KiB/s，小于等于0时不限速
+func (l *dynamicLimiter) SetLimits(lim limiter.Limits) {
+	setRate(l.upstream, lim.UploadKb)
+	setRate(l.downstream, lim.DownloadKb)
+}
+
+func setRate(bucket *rate.Limiter, kb int) {
+	if kb <= 0 {
+		bucket.SetLimit(rate.Inf)
+		return
+	}
+	bytes := kb * 1024
+	// 先调整突发值，避免等待中的请求超过突发值
+	bucket.SetBurst(bytes)
+	bucket.SetLimit(rate.Limit(bytes))
+}
+
+func (l *dynamicLimiter) Upstream(r io.Reader) io.Reader {
+	return &rateLimitedReader{reader: r, bucket: l.upstream}
+}
+
+func (l *dynamicLimiter) UpstreamWriter(w io.Writer) io.Writer {
+	return &rateLimitedWriter{writer: w, bucket: l.upstream}
+}
+
+func (l *dynamicLimiter) Downstream(r io.Reader) io.Reader {
+	return &rateLimitedReader{reader: r, bucket: l.downstream}
+}
+
+func (l *dynamicLimiter) DownstreamWriter(w io.Writer) io.Writer {
+	return &rateLimitedWriter{writer: w, bucket: l.downstream}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (l *dynamicLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
+	type readCloser struct {
+		io.Reader
+		io.Closer
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			req.Body = &readCloser{
+				Reader: l.Upstream(req.Body),
+				Closer: req.Body,
+			}
+		}
+		res, err := rt.RoundTrip(req)
+		if res != nil && res.Body != nil {
+			res.Body = &readCloser{
+				Reader: l.Downstream(res.Body),
+				Closer: res.Body,
+			}
+		}
+		return res, err
+	})
+}
+
+type rateLimitedReader struct {
+	reader io.Reader
+	bucket *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err := consumeTokens(n, r.bucket); err != nil {
+		return n, err
+	}
+	return n, err
+}
+
+type rateLimitedWriter struct {
+	writer io.Writer
+	bucket *rate.Limiter
+}
+
+func (w *rateLimitedWriter) Write(buf []byte) (int, error) {
+	if err := consumeTokens(len(buf), w.bucket); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(buf)
+}
+
+// consumeTokens 等待令牌，每次最多等待突发值个令牌，限速在等待期间可能被修改
+func consumeTokens(tokens int, bucket *rate.Limiter) error {
+	for tokens > 0 {
+		if bucket.Limit() == rate.Inf {
+			return nil
+		}
+		n := bucket.Burst()
+		if n <= 0 {
+			n = 1
+		}
+		if tokens < n {
+			n = tokens
+		}
+		if err := bucket.WaitN(context.Background(), n); err != nil {
+			// 限速被调小导致 n 超过突发值，重新读取后再等待
+			if n > bucket.Burst() {
+				continue
+			}
+			return err
+		}
+		tokens -= n
+	}
+	return nil
+}
+
+// repositoryLimits 获取存储库当前时段的限速
+func repositoryLimits(rep repoModel.Repository, t time.Time) limiter.Limits {
+	upload, download := rep.LimitsAt(t)
+	return limiter.Limits{
+		UploadKb:   upload,
+		DownloadKb: download,
+	}
+}
+
+// ApplyBandwidthLimits 按当前时段更新已加载存储库的限速
+func ApplyBandwidthLimits() {
+	reps, err := repositoryService.List(0, "", common.DBOptions{})
+	if err != nil {
+		if err.Error() != "not found" {
+			server.Logger().Error(err)
+		}
+		return
+	}
+	now := time.Now()
+	for _, rep := range reps {
+		r := Myrepositorys.Get(rep.Id)
+		if r.gopts.dynLimiter == nil {
+			continue
+		}
+		r.gopts.dynLimiter.SetLimits(repositoryLimits(rep, now))
+	}
+}
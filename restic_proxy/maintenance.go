@@ -0,0 +1,121 @@
+package resticProxy
+
+import (
+	"fmt"
+	maintenanceModel "github.com/kubackup/kubackup/internal/entity/v1/maintenance"
+	operationModel "github.com/kubackup/kubackup/internal/entity/v1/operation"
+	repoModel "github.com/kubackup/kubackup/internal/entity/v1/repository"
+	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	maintenanceDao "github.com/kubackup/kubackup/internal/service/v1/maintenance"
+	"github.com/kubackup/kubackup/internal/store/log"
+	"github.com/kubackup/kubackup/internal/store/ws_task_info"
+	"time"
+)
+
+// MaxRotateBuckets 轮换校验的最大分组数
+const MaxRotateBuckets = totalBucketsMax
+
+var maintenanceService maintenanceDao.Service
+
+func init() {
+	maintenanceService = maintenanceDao.GetService()
+}
+
+// RunMaintenance 执行存储库维护，返回操作记录id。
+// 设置了 SkipIfBusy 且存储库有任务执行或排队时跳过，并记录为已跳过的操作
+func RunMaintenance(m *maintenanceModel.Maintenance) (int, error) {
+	var operId int
+	var err error
+	if m.SkipIfBusy && RepoQueueBusy(m.RepositoryId) {
+		operId, err = skipMaintenance(m)
+	} else {
+		switch m.Type {
+		case maintenanceModel.TypeCheck:
+			operId, err = RunCheck(newMaintenanceCheckOptions(m), m.RepositoryId)
+		case maintenanceModel.TypePrune:
+			maxUnused := m.MaxUnused
+			if maxUnused == "" {
+				maxUnused = maintenanceModel.DefaultMaxUnused
+			}
+			operId, err = RunPrune(PruneOptions{
+				MaxUnused:          maxUnused,
+				MaxRepackSize:      m.MaxRepackSize,
+				RepackCachableOnly: m.RepackCachableOnly,
+			}, m.RepositoryId)
+		case maintenanceModel.TypeRebuildIndex:
+			operId, err = RunRebuildIndex(RebuildIndexOptions{
+				ReadAllPacks: m.ReadAllPacks,
+			}, m.RepositoryId)
+		case maintenanceModel.TypeCopy:
+			var opts CopyOptions
+			opts, err = NewCopyOptions(m.TargetRepositoryId, m.Hosts, m.Tags, m.Paths)
+			if err != nil {
+				return 0, err
+			}
+			operId, err = RunCopy(opts, m.RepositoryId)
+		default:
+			return 0, fmt.Errorf("不支持的维护类型：%d", m.Type)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = operationService.UpdateField(operId, "MaintenanceId", m.Id, common.DBOptions{})
+	if err != nil {
+		server.Logger().Error(err)
+	}
+	return operId, nil
+}
+
+// newMaintenanceCheckOptions 生成检查参数，开启轮换时读取上次校验分组的下一组，校验通过后记录分组
+func newMaintenanceCheckOptions(m *maintenanceModel.Maintenance) CheckOptions {
+	opts := CheckOptions{
+		ReadData:       m.ReadData,
+		ReadDataSubset: m.ReadDataSubset,
+		CheckUnused:    m.CheckUnused,
+		WithCache:      m.WithCache,
+	}
+	if m.RotateBuckets <= 0 {
+		return opts
+	}
+	bucket := NextRotateBucket(m.LastBucket, m.RotateBuckets)
+	opts.ReadData = false
+	opts.ReadDataSubset = fmt.Sprintf("%d/%d", bucket, m.RotateBuckets)
+	id := m.Id
+	opts.onSuccess = func() {
+		err := maintenanceService.UpdateField(id, "LastBucket", bucket, common.DBOptions{})
+		if err != nil {
+			server.Logger().Error(err)
+		}
+	}
+	return opts
+}
+
+// NextRotateBucket 获取下一个校验分组，从 1 开始，到 total 后重新开始
+func NextRotateBucket(last, total int) int {
+	if last < 0 {
+		last = 0
+	}
+	return last%total + 1
+}
+
+// skipMaintenance 记录跳过的维护操作
+func skipMaintenance(m *maintenanceModel.Maintenance) (int, error) {
+	logTask := log.LogInfo{}
+	logTask.SetId(0)
+	spr := wsTaskInfo.NewSprintf(&logTask)
+	spr.Append(wsTaskInfo.Warning, fmt.Sprintf("repository is busy, skip maintenance %s at %s\n", m.Name, time.Now().Format(TimeFormat)))
+	oper := operationModel.Operation{
+		RepositoryId:  m.RepositoryId,
+		MaintenanceId: m.Id,
+		Type:          m.Type,
+		Status:        repoModel.StatusSkip,
+		Logs:          spr.Sprints,
+	}
+	err := operationService.Create(&oper, common.DBOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return oper.Id, nil
+}
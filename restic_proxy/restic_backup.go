@@ -3,26 +3,35 @@ package resticProxy
 import (
 	"context"
 	"fmt"
+	planModel "github.com/kubackup/kubackup/internal/entity/v1/plan"
 	"github.com/kubackup/kubackup/internal/server"
 	"github.com/kubackup/kubackup/internal/service/v1/common"
+	planDao "github.com/kubackup/kubackup/internal/service/v1/plan"
 	ser "github.com/kubackup/kubackup/internal/service/v1/task"
 	"github.com/kubackup/kubackup/internal/store/task"
+	wsTaskInfo "github.com/kubackup/kubackup/internal/store/ws_task_info"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/archiver"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/filter"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/fs"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/ui"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/ui/backup"
 	"gopkg.in/tomb.v2"
 	"os"
+	"path"
 	"runtime"
+	"strings"
 	"time"
 )
 
 var taskHistoryService ser.Service
+var planService planDao.Service
 
 func init() {
 	taskHistoryService = ser.GetService()
+	planService = planDao.GetService()
 }
 
 var ErrInvalidSourceData = errors.New("at least one source file could not be read")
@@ -45,10 +54,107 @@ type BackupOptions struct {
 	IgnoreCtime       bool
 	UseFsSnapshot     bool
 	DryRun            bool
-	ReadConcurrency   uint //读取并发数量，默认2
+	ReadConcurrency   uint       //读取并发数量，默认2
+	Priority          int        //队列优先级，数值越大越先执行
+	PreHook           BackupHook //备份前执行的脚本
+	PostHook          BackupHook //备份后执行的脚本
+	StdinCommand      string     //执行命令并备份其标准输出，此时不扫描 targets
+	StdinFilename     string     //命令输出在快照中的文件名
 }
 
-func RunBackup(opts BackupOptions, repoid int, taskinfo task.TaskInfo) error {
+// DefaultGroupBy 查找父快照的默认分组方式，与 restic 保持一致
+const DefaultGroupBy = "host,paths"
+
+// NewBackupOptions 根据计划生成备份参数
+func NewBackupOptions(pl *planModel.Plan) (BackupOptions, error) {
+	groupBy := pl.GroupBy
+	if groupBy == "" {
+		groupBy = DefaultGroupBy
+	}
+	groupByOptions, err := SplitSnapshotGroupBy(groupBy)
+	if err != nil {
+		return BackupOptions{}, err
+	}
+	opts := BackupOptions{
+		GroupBy:           groupByOptions,
+		ExcludeOtherFS:    pl.ExcludeOtherFS,
+		ExcludeIfPresent:  pl.ExcludeIfPresent,
+		ExcludeCaches:     pl.ExcludeCaches,
+		ExcludeLargerThan: pl.ExcludeLargerThan,
+		Host:              pl.Host,
+		ReadConcurrency:   pl.ReadConcurrency,
+		Priority:          pl.Priority,
+	}
+	if len(pl.Tags) > 0 {
+		opts.Tags = restic.TagLists{pl.Tags}
+	}
+	opts.Excludes = pl.Excludes
+	opts.InsensitiveExcludes = pl.InsensitiveExcludes
+	opts.ExcludeFiles = pl.ExcludeFiles
+	opts.InsensitiveExcludeFiles = pl.InsensitiveExcludeFiles
+	if pl.SourceType == planModel.SourceTypeCommand {
+		opts.StdinCommand = pl.SourceCommand
+		opts.StdinFilename = pl.StdinFilename
+		if opts.StdinFilename == "" {
+			opts.StdinFilename = planModel.DefaultStdinFilename
+		}
+	}
+	hookTimeout := time.Duration(pl.HookTimeout) * time.Second
+	opts.PreHook = BackupHook{
+		Command:      pl.PreHook,
+		Timeout:      hookTimeout,
+		AbortOnError: pl.PreHookAbort,
+	}
+	opts.PostHook = BackupHook{
+		Command: pl.PostHook,
+		Timeout: hookTimeout,
+	}
+	return opts, nil
+}
+
+// VerifyBackupOptions 校验备份路径及排除规则
+func VerifyBackupOptions(opts BackupOptions, targets []string) error {
+	if opts.StdinCommand != "" {
+		if opts.StdinFilename == "" || strings.ContainsAny(opts.StdinFilename, "/\\") {
+			return errors.Fatalf("--stdin-filename: invalid filename %q", opts.StdinFilename)
+		}
+	} else if len(targets) == 0 {
+		return errors.Fatal("path不能为空")
+	}
+	if len(opts.Excludes) > 0 {
+		if err := filter.ValidatePatterns(opts.Excludes); err != nil {
+			return errors.Fatalf("--exclude: %s", err)
+		}
+	}
+	if len(opts.InsensitiveExcludes) > 0 {
+		if err := filter.ValidatePatterns(opts.InsensitiveExcludes); err != nil {
+			return errors.Fatalf("--iexclude: %s", err)
+		}
+	}
+	for _, spec := range opts.ExcludeIfPresent {
+		if spec == "" || strings.HasPrefix(spec, ":") {
+			return errors.Fatalf("--exclude-if-present: invalid tagfile %q", spec)
+		}
+	}
+	if opts.ExcludeLargerThan != "" {
+		if _, err := ui.ParseBytes(opts.ExcludeLargerThan); err != nil {
+			return errors.Fatalf("--exclude-larger-than: %s", err)
+		}
+	}
+	for _, tag := range opts.Tags.Flatten() {
+		if tag == "" || strings.Contains(tag, ",") {
+			return errors.Fatalf("--tag: invalid tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// RunBackup 备份 targets 到仓库 repoid，任务在存储库队列中排队后异步执行
+func RunBackup(opts BackupOptions, repoid int, targets []string, taskinfo task.TaskInfo) error {
+	err := VerifyBackupOptions(opts, targets)
+	if err != nil {
+		return err
+	}
 	if opts.Host == "" {
 		hostname, err := os.Hostname()
 		if err != nil {
@@ -60,7 +166,9 @@ func RunBackup(opts BackupOptions, repoid int, taskinfo task.TaskInfo) error {
 	if err != nil {
 		return err
 	}
-	repo := repoHandler.repo
+	if opts.StdinCommand != "" {
+		targets = []string{path.Join("/", opts.StdinFilename)}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	clean := NewCleanCtx()
@@ -68,45 +176,106 @@ func RunBackup(opts BackupOptions, repoid int, taskinfo task.TaskInfo) error {
 		cancel()
 	})
 
-	targets := []string{taskinfo.Path}
-	timeStamp := time.Now()
-
 	var t tomb.Tomb
 	progressPrinter := NewTaskProgress(&taskinfo, time.Second)
-	progressReporter := backup.NewProgress(progressPrinter, time.Second)
-	clean.AddCleanCtx(func() {
-		progressReporter.Done()
+	progressPrinter.SetPostHook(opts.PostHook)
+	bound := make(chan string)
+	taskinfo.SetBound(bound)
+	taskinfo.SetCancel(cancel)
+	task.TaskInfos.Set(taskinfo.GetId(), &taskinfo)
+	t.Go(func() error {
+		for {
+			select {
+			case <-t.Context(ctx).Done():
+				return nil
+			case <-task.TaskInfos.Get(taskinfo.GetId()).GetBound():
+				info := task.TaskInfos.Get(taskinfo.GetId())
+				progressPrinter.UpdateTaskInfo(info)
+			}
+		}
 	})
+	go func() {
+		defer clean.Cleanup()
+		release, err := WaitRepoQueue(ctx, repoid, QueueOptions{
+			Priority: opts.Priority,
+			Key:      taskinfo.Path,
+			OnQueued: func() {
+				err := taskHistoryService.UpdateField(taskinfo.GetId(), "Status", task.StatusQueued, common.DBOptions{})
+				if err != nil {
+					server.Logger().Error(err)
+				}
+			},
+		})
+		if err == nil {
+			err = runBackup(ctx, opts, repoHandler.repo, targets, &taskinfo, progressPrinter, &t)
+			release()
+		}
+		if err != nil {
+			// 未能开始备份，直接结束任务
+			if !taskinfo.IsCancelled() {
+				progressPrinter.E(err.Error())
+			}
+			t.Kill(nil)
+			_ = t.Wait()
+			progressPrinter.Finish(restic.ID{}, time.Now(), nil, opts.DryRun)
+		}
+	}()
+	return nil
+}
+
+// runBackup 执行备份直至结束，返回错误时任务尚未结束，由调用方处理
+func runBackup(ctx context.Context, opts BackupOptions, repo *repository.Repository, targets []string, taskinfo *task.TaskInfo, progressPrinter *TaskProgress, t *tomb.Tomb) error {
+	err := taskHistoryService.UpdateField(taskinfo.GetId(), "Status", task.StatusRunning, common.DBOptions{})
+	if err != nil {
+		server.Logger().Error(err)
+	}
+	timeStamp := time.Now()
+	if opts.PreHook.Command != "" {
+		ta, err := taskHistoryService.Get(taskinfo.GetId(), common.DBOptions{})
+		if err != nil {
+			server.Logger().Error(err)
+		}
+		err = progressPrinter.RunHook(ctx, HookPre, opts.PreHook, ta)
+		if err != nil && (opts.PreHook.AbortOnError || taskinfo.IsCancelled()) {
+			// 备份前脚本失败，终止备份
+			if !taskinfo.IsCancelled() {
+				progressPrinter.hookError(HookPre, err)
+			}
+			t.Kill(nil)
+			_ = t.Wait()
+			progressPrinter.Finish(restic.ID{}, timeStamp, nil, opts.DryRun)
+			return nil
+		}
+	}
+
+	progressReporter := backup.NewProgress(progressPrinter, time.Second)
+	defer progressReporter.Done()
 	if opts.DryRun {
 		repo.SetDryRun()
 	}
 	lock, err := lockRepo(ctx, repo)
 	if err != nil {
-		clean.Cleanup()
 		return err
 	}
-	clean.AddCleanCtx(func() {
-		unlockRepo(lock)
-	})
+	defer unlockRepo(lock)
 	rejectByNameFuncs, err := collectRejectByNameFuncs(opts, repo)
 	if err != nil {
-		clean.Cleanup()
 		return err
 	}
-	rejectFuncs, err := collectRejectFuncs(opts, targets)
-	if err != nil {
-		clean.Cleanup()
-		return err
+	var rejectFuncs []RejectFunc
+	if opts.StdinCommand == "" {
+		rejectFuncs, err = collectRejectFuncs(opts, targets)
+		if err != nil {
+			return err
+		}
 	}
 	parentSnapshot, err := findParentSnapshot(ctx, repo, opts, targets, timeStamp)
 	if err != nil {
-		clean.Cleanup()
 		return err
 	}
 	if parentSnapshot != nil {
 		err = taskHistoryService.UpdateField(taskinfo.GetId(), "ParentId", parentSnapshot.ID().Str(), common.DBOptions{})
 		if err != nil {
-			clean.Cleanup()
 			return err
 		}
 	}
@@ -129,7 +298,17 @@ func RunBackup(opts BackupOptions, repoid int, taskinfo task.TaskInfo) error {
 		return true
 	}
 	var targetFS fs.FS = fs.Local{}
-	if runtime.GOOS == "windows" && opts.UseFsSnapshot {
+	if opts.StdinCommand != "" {
+		// 命令输出作为单个虚拟文件备份
+		targetFS = &fs.Reader{
+			ModTime: timeStamp,
+			Name:    targets[0],
+			Mode:    0644,
+			ReadCloser: NewCommandReader(ctx, opts.StdinCommand, func(line string) {
+				progressPrinter.hookLog(HookCommand, wsTaskInfo.Warning, line)
+			}),
+		}
+	} else if runtime.GOOS == "windows" && opts.UseFsSnapshot {
 		if err = fs.HasSufficientPrivilegesForVSS(); err != nil {
 			return err
 		}
@@ -152,8 +331,6 @@ func RunBackup(opts BackupOptions, repoid int, taskinfo task.TaskInfo) error {
 	sc.Error = progressPrinter.ScannerError
 	sc.Result = progressReporter.ReportTotal
 
-	t.Go(func() error { return sc.Scan(t.Context(ctx), targets) })
-
 	arch := archiver.New(repo, targetFS, archiver.Options{
 		ReadConcurrency: opts.ReadConcurrency,
 	})
@@ -186,50 +363,23 @@ func RunBackup(opts BackupOptions, repoid int, taskinfo task.TaskInfo) error {
 		ParentSnapshot: parentSnapshot,
 		ProgramVersion: "restic " + version,
 	}
-	bound := make(chan string)
-	taskinfo.SetBound(bound)
-	task.TaskInfos.Set(taskinfo.GetId(), &taskinfo)
-	t.Go(func() error {
-		for {
-			select {
-			case <-t.Context(ctx).Done():
-				return nil
-			case <-task.TaskInfos.Get(taskinfo.GetId()).GetBound():
-				info := task.TaskInfos.Get(taskinfo.GetId())
-				progressPrinter.UpdateTaskInfo(info)
-			}
-		}
-	})
-	if !BackupLock(repoid, taskinfo.Path) {
-		clean.Cleanup()
-		return fmt.Errorf("存储库\"%d\"正在备份：%s", repoid, taskinfo.Path)
+	t.Go(func() error { return sc.Scan(t.Context(ctx), targets) })
+	_, id, err := arch.Snapshot(ctx, targets, snapshotOpts)
+	if err != nil && !taskinfo.IsCancelled() {
+		progressPrinter.E(fmt.Errorf("unable to save snapshot: %v", err).Error())
 	}
-	clean.AddCleanCtx(func() {
-		BackupUnLock(repoid, taskinfo.Path)
-	})
-	go func() {
-		defer clean.Cleanup()
-		err = taskHistoryService.UpdateField(taskinfo.GetId(), "Status", task.StatusRunning, common.DBOptions{})
-		if err != nil {
-			server.Logger().Error(err)
-		}
-		_, id, err := arch.Snapshot(ctx, targets, snapshotOpts)
-		if err != nil {
-			progressPrinter.E(fmt.Errorf("unable to save snapshot: %v", err).Error())
-		}
-		t.Kill(nil)
-		werr := t.Wait()
-		if werr != nil {
-			server.Logger().Error(werr)
-		}
-		if !success {
-			progressPrinter.E(ErrInvalidSourceData.Error())
-		}
-		progressReporter.Finish(id, opts.DryRun)
-	}()
+	t.Kill(nil)
+	werr := t.Wait()
+	if werr != nil {
+		server.Logger().Error(werr)
+	}
+	if !success && !taskinfo.IsCancelled() {
+		progressPrinter.E(ErrInvalidSourceData.Error())
+	}
+	progressReporter.Finish(id, opts.DryRun)
 	return nil
-
 }
+
 func findParentSnapshot(ctx context.Context, repo restic.Repository, opts BackupOptions, targets []string, timeStampLimit time.Time) (*restic.Snapshot, error) {
 	if opts.Force {
 		return nil, nil
@@ -38,6 +38,8 @@ type CheckOptions struct {
 	CheckUnused    bool   //find unused blobs
 	WithCache      bool   //use the cache
 	NoLock         bool
+
+	onSuccess func() //检查通过后回调
 }
 
 func checkFlags(opts CheckOptions) error {
@@ -200,16 +202,6 @@ func RunCheck(opts CheckOptions, repoid int) (int, error) {
 		cancel()
 	})
 	repo := repoHandler.repo
-	if !opts.NoLock {
-		lock, err := lockRepoExclusive(ctx, repo)
-		if err != nil {
-			clean.Cleanup()
-			return 0, err
-		}
-		clean.AddCleanCtx(func() {
-			unlockRepo(lock)
-		})
-	}
 	status := repoModel.StatusNone
 	oper := operationModel.Operation{
 		RepositoryId: repoid,
@@ -228,6 +220,7 @@ func RunCheck(opts CheckOptions, repoid int) (int, error) {
 	spr := wsTaskInfo.NewSprintf(&logTask)
 
 	logTask.SetBound(make(chan string))
+	logTask.SetCancel(cancel)
 	log.LogInfos.Set(oper.Id, &logTask)
 	t.Go(func() error {
 		for {
@@ -243,13 +236,25 @@ func RunCheck(opts CheckOptions, repoid int) (int, error) {
 	})
 	t.Go(func() error {
 		defer clean.Cleanup()
-		err := check(repo, opts, gopts, ctx, spr)
+		var err error
+		if !opts.NoLock {
+			err = lockRepoExclusiveQueued(ctx, repoid, repo, clean, spr)
+		}
+		if err == nil {
+			err = check(repo, opts, gopts, ctx, spr)
+		}
 		status = repoModel.StatusNone
-		if err != nil {
+		if logTask.IsCancelled() {
+			spr.Append(wsTaskInfo.Warning, "cancelled")
+			status = repoModel.StatusCancel
+		} else if err != nil {
 			spr.Append(wsTaskInfo.Error, err.Error())
 			status = repoModel.StatusErr
 		} else {
 			status = repoModel.StatusRun
+			if opts.onSuccess != nil {
+				opts.onSuccess()
+			}
 		}
 		oper.Status = status
 		oper.Logs = spr.Sprints
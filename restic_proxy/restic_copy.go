@@ -0,0 +1,285 @@
+package resticProxy
+
+import (
+	"context"
+	"fmt"
+	operationModel "github.com/kubackup/kubackup/internal/entity/v1/operation"
+	repoModel "github.com/kubackup/kubackup/internal/entity/v1/repository"
+	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"github.com/kubackup/kubackup/internal/store/log"
+	"github.com/kubackup/kubackup/internal/store/ws_task_info"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"github.com/restic/chunker"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/tomb.v2"
+	"strings"
+)
+
+// CopyOptions 复制快照参数
+type CopyOptions struct {
+	TargetRepositoryId int      //目标存储库
+	SnapshotIDs        []string //指定快照，为空时复制所有匹配过滤条件的快照
+	restic.SnapshotFilter
+}
+
+// NewCopyOptions 生成复制参数，hosts、paths 以逗号分隔，tags 同 restic --tag
+func NewCopyOptions(target int, hosts, tags, paths string) (CopyOptions, error) {
+	opts := CopyOptions{TargetRepositoryId: target}
+	if hosts != "" {
+		opts.Hosts = strings.Split(hosts, ",")
+	}
+	if paths != "" {
+		opts.Paths = strings.Split(paths, ",")
+	}
+	if tags != "" {
+		err := opts.Tags.Set(tags)
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// RunCopy 将快照复制到另一个存储库，只传输目标存储库中不存在的数据，目标中已有相同快照时跳过
+func RunCopy(opts CopyOptions, repoid int) (int, error) {
+	if opts.TargetRepositoryId == repoid {
+		return 0, fmt.Errorf("源存储库与目标存储库不能相同")
+	}
+	srcHandler, err := GetRepository(repoid)
+	if err != nil {
+		return 0, err
+	}
+	dstHandler, err := GetRepository(opts.TargetRepositoryId)
+	if err != nil {
+		return 0, err
+	}
+	srcRepo := srcHandler.repo
+	dstRepo := dstHandler.repo
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clean := NewCleanCtx()
+	clean.AddCleanCtx(func() {
+		cancel()
+	})
+
+	status := repoModel.StatusNone
+	oper := operationModel.Operation{
+		RepositoryId:       repoid,
+		TargetRepositoryId: opts.TargetRepositoryId,
+		Type:               operationModel.COPY_TYPE,
+		Status:             status,
+		Logs:               make([]*wsTaskInfo.Sprint, 0),
+	}
+	err = operationService.Create(&oper, common.DBOptions{})
+	if err != nil {
+		clean.Cleanup()
+		return 0, err
+	}
+	var t tomb.Tomb
+	logTask := log.LogInfo{}
+	logTask.SetId(oper.Id)
+	spr := wsTaskInfo.NewSprintf(&logTask)
+
+	logTask.SetBound(make(chan string))
+	logTask.SetCancel(cancel)
+	log.LogInfos.Set(oper.Id, &logTask)
+	t.Go(func() error {
+		for {
+			select {
+			case <-t.Context(ctx).Done():
+				return nil
+			case <-log.LogInfos.Get(oper.Id).GetBound():
+				info := log.LogInfos.Get(oper.Id)
+				spr.UpdateTaskInfo(info)
+				spr.SendAllLog()
+			}
+		}
+	})
+	t.Go(func() error {
+		defer clean.Cleanup()
+		err := lockRepoQueued(ctx, repoid, srcRepo, false, clean, spr)
+		if err == nil {
+			err = lockRepoQueued(ctx, opts.TargetRepositoryId, dstRepo, false, clean, spr)
+		}
+		if err == nil {
+			err = copySnapshots(ctx, opts, srcRepo, dstRepo, spr)
+		}
+		status = repoModel.StatusNone
+		if logTask.IsCancelled() {
+			spr.Append(wsTaskInfo.Warning, "cancelled")
+			status = repoModel.StatusCancel
+		} else if err != nil {
+			spr.Append(wsTaskInfo.Error, err.Error())
+			status = repoModel.StatusErr
+		} else {
+			status = repoModel.StatusRun
+		}
+		oper.Status = status
+		oper.Logs = spr.Sprints
+		err = operationService.Update(&oper, common.DBOptions{})
+		if err != nil {
+			server.Logger().Error(err)
+		}
+		t.Kill(nil)
+		log.LogInfos.Close(oper.Id, "process end", 1)
+		return nil
+	})
+
+	return oper.Id, nil
+}
+
+func copySnapshots(ctx context.Context, opts CopyOptions, srcRepo, dstRepo *repository.Repository, spr *wsTaskInfo.Sprintf) error {
+	if srcRepo.Config().ChunkerPolynomial != dstRepo.Config().ChunkerPolynomial {
+		spr.Append(wsTaskInfo.Warning, "the source and destination repository use different chunker parameters, copied data cannot be deduplicated against later backups to the destination\n")
+	}
+	srcSnapshotLister, err := backend.MemorizeList(ctx, srcRepo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+	dstSnapshotLister, err := backend.MemorizeList(ctx, dstRepo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return err
+	}
+
+	// 按原始快照id查找目标存储库中已复制的快照
+	dstSnapshotByOriginal := make(map[restic.ID][]*restic.Snapshot)
+	for sn := range FindFilteredSnapshots(ctx, dstSnapshotLister, dstRepo, &opts.SnapshotFilter, nil) {
+		if sn.Original != nil && !sn.Original.IsNull() {
+			dstSnapshotByOriginal[*sn.Original] = append(dstSnapshotByOriginal[*sn.Original], sn)
+		}
+		dstSnapshotByOriginal[*sn.ID()] = append(dstSnapshotByOriginal[*sn.ID()], sn)
+	}
+
+	// 多个快照共用的树只处理一次
+	visitedTrees := restic.NewIDSet()
+	var copied, skipped int
+	for sn := range FindFilteredSnapshots(ctx, srcSnapshotLister, srcRepo, &opts.SnapshotFilter, opts.SnapshotIDs) {
+		srcOriginal := *sn.ID()
+		if sn.Original != nil {
+			srcOriginal = *sn.Original
+		}
+		isCopy := false
+		for _, originalSn := range dstSnapshotByOriginal[srcOriginal] {
+			if similarSnapshots(originalSn, sn) {
+				isCopy = true
+				break
+			}
+		}
+		if isCopy {
+			skipped++
+			continue
+		}
+		spr.Append(wsTaskInfo.Info, fmt.Sprintf("copy snapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time.Format(TimeFormat)))
+		if sn.Original == nil {
+			sn.Original = sn.ID()
+		}
+		err = copyTree(ctx, srcRepo, dstRepo, visitedTrees, *sn.Tree, spr)
+		if err != nil {
+			return err
+		}
+		// 父快照在目标存储库中没有意义，Original 作为快照的持久id
+		sn.Parent = nil
+		newID, err := restic.SaveSnapshot(ctx, dstRepo, sn)
+		if err != nil {
+			return err
+		}
+		copied++
+		spr.Append(wsTaskInfo.Success, fmt.Sprintf("snapshot %s saved\n", newID.Str()))
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	spr.Append(wsTaskInfo.Success, fmt.Sprintf("copied %d snapshots, %d already exist in the destination\n", copied, skipped))
+	return nil
+}
+
+// similarSnapshots 除 Parent 及 Original 外其他字段均相同时视为同一快照
+func similarSnapshots(sna *restic.Snapshot, snb *restic.Snapshot) bool {
+	if !sna.Time.Equal(snb.Time) || !sna.Tree.Equal(*snb.Tree) || sna.Hostname != snb.Hostname ||
+		sna.Username != snb.Username || sna.UID != snb.UID || sna.GID != snb.GID ||
+		len(sna.Paths) != len(snb.Paths) || len(sna.Excludes) != len(snb.Excludes) ||
+		len(sna.Tags) != len(snb.Tags) {
+		return false
+	}
+	if !sna.HasPaths(snb.Paths) || !sna.HasTags(snb.Tags) {
+		return false
+	}
+	for i, a := range sna.Excludes {
+		if a != snb.Excludes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// copyTree 收集目标存储库中缺少的树及数据块，按数据包重新打包到目标存储库
+func copyTree(ctx context.Context, srcRepo, dstRepo *repository.Repository, visitedTrees restic.IDSet, rootTreeID restic.ID, spr *wsTaskInfo.Sprintf) error {
+	wg, wgCtx := errgroup.WithContext(ctx)
+	treeStream := restic.StreamTrees(wgCtx, wg, srcRepo, restic.IDs{rootTreeID}, func(treeID restic.ID) bool {
+		visited := visitedTrees.Has(treeID)
+		visitedTrees.Insert(treeID)
+		return visited
+	}, nil)
+
+	copyBlobs := restic.NewBlobSet()
+	packList := restic.NewIDSet()
+
+	enqueue := func(h restic.BlobHandle) {
+		pb := srcRepo.Index().Lookup(h)
+		copyBlobs.Insert(h)
+		for _, p := range pb {
+			packList.Insert(p.PackID)
+		}
+	}
+
+	wg.Go(func() error {
+		for tree := range treeStream {
+			if tree.Error != nil {
+				return fmt.Errorf("LoadTree(%v) returned error %v", tree.ID.Str(), tree.Error)
+			}
+			// 直接复制树的原始数据，避免序列化方式变化导致 id 不同
+			treeHandle := restic.BlobHandle{ID: tree.ID, Type: restic.TreeBlob}
+			if !dstRepo.Index().Has(treeHandle) {
+				enqueue(treeHandle)
+			}
+			for _, entry := range tree.Nodes {
+				for _, blobID := range entry.Content {
+					h := restic.BlobHandle{Type: restic.DataBlob, ID: blobID}
+					if !dstRepo.Index().Has(h) {
+						enqueue(h)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	err := wg.Wait()
+	if err != nil {
+		return err
+	}
+	if len(packList) == 0 {
+		return nil
+	}
+
+	bar := newProgressMax(true, uint64(len(packList)), "packs copied", spr)
+	_, err = repository.Repack(ctx, srcRepo, dstRepo, packList, copyBlobs, bar)
+	bar.Done()
+	if err != nil {
+		return errors.Fatal(err.Error())
+	}
+	return nil
+}
+
+// chunkerPolynomial 获取存储库的分块参数，用于初始化目标存储库，使复制的数据可以去重
+func chunkerPolynomial(repoid int) (*chunker.Pol, error) {
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return nil, err
+	}
+	pol := repoHandler.repo.Config().ChunkerPolynomial
+	return &pol, nil
+}
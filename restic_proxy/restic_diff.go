@@ -0,0 +1,296 @@
+package resticProxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/walker"
+	"path"
+	"sort"
+)
+
+// 变更类型，与 restic diff 输出一致
+const (
+	DiffAdded    = "+" //新增
+	DiffRemoved  = "-" //删除
+	DiffModified = "M" //内容变更
+	DiffType     = "T" //类型变更
+	DiffMetadata = "U" //仅元数据变更
+)
+
+// DiffNode 单个路径的变更
+type DiffNode struct {
+	Path      string   `json:"path"`
+	Type      string   `json:"type"`
+	Modifier  string   `json:"modifier"`
+	OldSize   uint64   `json:"oldSize"`
+	NewSize   uint64   `json:"newSize"`
+	SizeDelta int64    `json:"sizeDelta"`
+	Changes   []string `json:"changes"` //变更的元数据，如 mode、mtime、uid、gid
+}
+
+// DiffStat 变更统计
+type DiffStat struct {
+	Added       int   `json:"added"`
+	Removed     int   `json:"removed"`
+	Modified    int   `json:"modified"`
+	AddedSize   int64 `json:"addedSize"`
+	RemovedSize int64 `json:"removedSize"`
+}
+
+type DiffRes struct {
+	SnapshotA lsSnapshot    `json:"snapshotA"`
+	SnapshotB lsSnapshot    `json:"snapshotB"`
+	Stat      DiffStat      `json:"stat"`
+	Nodes     []interface{} `json:"nodes"`
+}
+
+// RunDiff 比较两个快照，prefix 不为空时只比较该路径下的变更
+func RunDiff(repoid int, snapshotA, snapshotB string, prefix string) (*DiffRes, error) {
+	if snapshotA == "" || snapshotB == "" {
+		return nil, errors.Errorf("no snapshot ID specified")
+	}
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return nil, err
+	}
+	repo := repoHandler.repo
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clean := NewCleanCtx()
+	clean.AddCleanCtx(func() {
+		cancel()
+	})
+	defer clean.Cleanup()
+
+	snapshotLister, err := backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	snA, subfolderA, err := (&restic.SnapshotFilter{}).FindLatest(ctx, snapshotLister, repo, snapshotA)
+	if err != nil {
+		return nil, err
+	}
+	snB, subfolderB, err := (&restic.SnapshotFilter{}).FindLatest(ctx, snapshotLister, repo, snapshotB)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		prefix = "/"
+	}
+	prefix = path.Clean("/" + prefix)
+	treeA := findDiffTree(ctx, repo, snA, path.Join(subfolderA, prefix))
+	treeB := findDiffTree(ctx, repo, snB, path.Join(subfolderB, prefix))
+	if treeA == nil && treeB == nil {
+		return nil, fmt.Errorf("path %q not found in snapshot", prefix)
+	}
+
+	d := &differ{ctx: ctx, repo: repo, nodes: make([]interface{}, 0)}
+	err = d.diffTree(prefix, treeA, treeB)
+	if err != nil {
+		return nil, err
+	}
+	return &DiffRes{
+		SnapshotA: lsSnapshot{Snapshot: snA, ID: snA.ID(), ShortID: snA.ID().Str(), StructType: "snapshot"},
+		SnapshotB: lsSnapshot{Snapshot: snB, ID: snB.ID(), ShortID: snB.ID().Str(), StructType: "snapshot"},
+		Stat:      d.stat,
+		Nodes:     d.nodes,
+	}, nil
+}
+
+// findDiffTree 查找快照中 p 对应的目录，不存在时返回 nil
+func findDiffTree(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, p string) *restic.ID {
+	id, err := restic.FindTreeDirectory(ctx, repo, sn.Tree, p)
+	if err != nil {
+		return nil
+	}
+	return id
+}
+
+type differ struct {
+	ctx   context.Context
+	repo  *repository.Repository
+	stat  DiffStat
+	nodes []interface{}
+}
+
+func (d *differ) loadNodes(id *restic.ID) (map[string]*restic.Node, []string, error) {
+	nodes := make(map[string]*restic.Node)
+	names := make([]string, 0)
+	if id == nil {
+		return nodes, names, nil
+	}
+	tree, err := restic.LoadTree(d.ctx, d.repo, *id)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, node := range tree.Nodes {
+		nodes[node.Name] = node
+		names = append(names, node.Name)
+	}
+	return nodes, names, nil
+}
+
+// diffTree 按名称合并比较两个目录，目录 id 相同时跳过
+func (d *differ) diffTree(prefix string, a, b *restic.ID) error {
+	if a != nil && b != nil && a.Equal(*b) {
+		return nil
+	}
+	nodesA, namesA, err := d.loadNodes(a)
+	if err != nil {
+		return err
+	}
+	nodesB, namesB, err := d.loadNodes(b)
+	if err != nil {
+		return err
+	}
+	names := namesA
+	for _, name := range namesB {
+		if _, ok := nodesA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := path.Join(prefix, name)
+		nodeA, okA := nodesA[name]
+		nodeB, okB := nodesB[name]
+		switch {
+		case okA && !okB:
+			err = d.addAll(p, nodeA, DiffRemoved)
+		case !okA && okB:
+			err = d.addAll(p, nodeB, DiffAdded)
+		case nodeA.Type != nodeB.Type:
+			d.add(p, nodeA, nodeB, DiffType, nil)
+			if nodeA.Type == "dir" {
+				err = d.addChildren(p, nodeA, DiffRemoved)
+			}
+			if err == nil && nodeB.Type == "dir" {
+				err = d.addChildren(p, nodeB, DiffAdded)
+			}
+		default:
+			changes := metadataChanges(nodeA, nodeB)
+			if nodeA.Type == "dir" {
+				if len(changes) > 0 {
+					d.add(p, nodeA, nodeB, DiffMetadata, changes)
+				}
+				err = d.diffTree(p, nodeA.Subtree, nodeB.Subtree)
+			} else if !contentEqual(nodeA, nodeB) {
+				d.add(p, nodeA, nodeB, DiffModified, changes)
+			} else if len(changes) > 0 {
+				d.add(p, nodeA, nodeB, DiffMetadata, changes)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addAll 记录新增或删除的节点，目录时包含其下所有内容
+func (d *differ) addAll(p string, node *restic.Node, modifier string) error {
+	if modifier == DiffAdded {
+		d.add(p, nil, node, modifier, nil)
+	} else {
+		d.add(p, node, nil, modifier, nil)
+	}
+	if node.Type != "dir" {
+		return nil
+	}
+	return d.addChildren(p, node, modifier)
+}
+
+// addChildren 遍历目录下所有内容
+func (d *differ) addChildren(p string, node *restic.Node, modifier string) error {
+	if node.Subtree == nil {
+		return errors.Errorf("subtree for node %v is nil", p)
+	}
+	return walker.Walk(d.ctx, d.repo, *node.Subtree, restic.NewIDSet(), func(_ restic.ID, npath string, n *restic.Node, nodeErr error) (bool, error) {
+		if nodeErr != nil {
+			return true, nodeErr
+		}
+		if n == nil {
+			return true, nil
+		}
+		if modifier == DiffAdded {
+			d.add(path.Join(p, npath), nil, n, modifier, nil)
+		} else {
+			d.add(path.Join(p, npath), n, nil, modifier, nil)
+		}
+		return false, nil
+	})
+}
+
+func (d *differ) add(p string, a, b *restic.Node, modifier string, changes []string) {
+	if changes == nil {
+		changes = make([]string, 0)
+	}
+	n := DiffNode{
+		Path:     p,
+		Modifier: modifier,
+		Changes:  changes,
+	}
+	if a != nil {
+		n.Type = a.Type
+		if a.Type == "file" {
+			n.OldSize = a.Size
+		}
+	}
+	if b != nil {
+		n.Type = b.Type
+		if b.Type == "file" {
+			n.NewSize = b.Size
+		}
+	}
+	n.SizeDelta = int64(n.NewSize) - int64(n.OldSize)
+	switch modifier {
+	case DiffAdded:
+		d.stat.Added++
+		d.stat.AddedSize += int64(n.NewSize)
+	case DiffRemoved:
+		d.stat.Removed++
+		d.stat.RemovedSize += int64(n.OldSize)
+	default:
+		d.stat.Modified++
+	}
+	d.nodes = append(d.nodes, n)
+}
+
+// contentEqual 比较文件内容及链接目标
+func contentEqual(a, b *restic.Node) bool {
+	if a.LinkTarget != b.LinkTarget || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !a.Content[i].Equal(b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataChanges 列出变更的元数据
+func metadataChanges(a, b *restic.Node) []string {
+	changes := make([]string, 0)
+	if a.Mode != b.Mode {
+		changes = append(changes, "mode")
+	}
+	if !a.ModTime.Equal(b.ModTime) {
+		changes = append(changes, "mtime")
+	}
+	if a.UID != b.UID || a.User != b.User {
+		changes = append(changes, "uid")
+	}
+	if a.GID != b.GID || a.Group != b.Group {
+		changes = append(changes, "gid")
+	}
+	if a.Size != b.Size {
+		changes = append(changes, "size")
+	}
+	return changes
+}
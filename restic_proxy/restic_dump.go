@@ -0,0 +1,221 @@
+package resticProxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/backend"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/dump"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 目录打包格式
+const (
+	DumpArchiveTar = "tar"
+	DumpArchiveZip = "zip"
+)
+
+// DumpTarget 快照中待下载的文件或目录
+type DumpTarget struct {
+	repo    *repository.Repository
+	node    *restic.Node //为空时表示快照根目录
+	tree    *restic.Tree //目录对应的树
+	Path    string
+	Name    string
+	IsDir   bool
+	Size    uint64
+	ModTime time.Time
+}
+
+// OpenDump 在快照中查找文件或目录，p 为空或 / 时表示整个快照
+func OpenDump(ctx context.Context, repoid int, snapshotid string, p string) (*DumpTarget, error) {
+	if snapshotid == "" {
+		return nil, errors.Errorf("no snapshot ID specified")
+	}
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return nil, err
+	}
+	repo := repoHandler.repo
+
+	snapshotLister, err := backend.MemorizeList(ctx, repo.Backend(), restic.SnapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	sn, subfolder, err := (&restic.SnapshotFilter{}).FindLatest(ctx, snapshotLister, repo, snapshotid)
+	if err != nil {
+		return nil, err
+	}
+	sn.Tree, err = restic.FindTreeDirectory(ctx, repo, sn.Tree, subfolder)
+	if err != nil {
+		return nil, err
+	}
+	if sn.Tree == nil {
+		return nil, fmt.Errorf("snapshot 404")
+	}
+	tree, err := restic.LoadTree(ctx, repo, *sn.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &DumpTarget{
+		repo:    repo,
+		tree:    tree,
+		Path:    "/",
+		Name:    sn.ID().Str(),
+		IsDir:   true,
+		ModTime: sn.Time,
+	}
+	item := "/"
+	components := splitDumpPath(p)
+	for i, name := range components {
+		item = path.Join(item, name)
+		var node *restic.Node
+		for _, n := range tree.Nodes {
+			if n.Name == name {
+				node = n
+				break
+			}
+		}
+		if node == nil {
+			return nil, fmt.Errorf("path %q not found in snapshot", item)
+		}
+		last := i == len(components)-1
+		switch {
+		case dump.IsDir(node):
+			if node.Subtree == nil {
+				return nil, fmt.Errorf("%q has no subtree", item)
+			}
+			tree, err = restic.LoadTree(ctx, repo, *node.Subtree)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot load subtree for %q", item)
+			}
+		case !last:
+			return nil, fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
+		case !dump.IsFile(node):
+			return nil, fmt.Errorf("%q should be a file, but is a %q", item, node.Type)
+		}
+		if last {
+			target.node = node
+			target.tree = tree
+			target.Path = item
+			target.Name = node.Name
+			target.IsDir = dump.IsDir(node)
+			target.Size = node.Size
+			target.ModTime = node.ModTime
+		}
+	}
+	return target, nil
+}
+
+// splitDumpPath 将路径拆分为各级名称
+func splitDumpPath(p string) []string {
+	res := make([]string, 0)
+	for _, s := range strings.Split(path.Clean("/"+p), "/") {
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// WriteArchive 将文件或目录按 tar/zip 格式写入 w
+func (d *DumpTarget) WriteArchive(ctx context.Context, format string, w io.Writer) error {
+	if format != DumpArchiveTar && format != DumpArchiveZip {
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+	dumper := dump.New(format, d.repo, w)
+	if d.IsDir {
+		return dumper.DumpTree(ctx, d.tree, d.Path)
+	}
+	// 单个文件打包时，构造仅包含该文件的目录
+	tree := &restic.Tree{Nodes: []*restic.Node{d.node}}
+	return dumper.DumpTree(ctx, tree, path.Dir(d.Path))
+}
+
+// NewFileReader 获取文件内容，支持 Seek 以便按 Range 下载
+func (d *DumpTarget) NewFileReader(ctx context.Context) (io.ReadSeeker, error) {
+	if d.IsDir || d.node == nil {
+		return nil, fmt.Errorf("%q is not a file", d.Path)
+	}
+	return newBlobFileReader(ctx, d.repo, d.node)
+}
+
+func newBlobFileReader(ctx context.Context, repo *repository.Repository, node *restic.Node) (*blobFileReader, error) {
+	offsets := make([]int64, len(node.Content))
+	var size int64
+	for i, id := range node.Content {
+		offsets[i] = size
+		blobSize, ok := repo.LookupBlobSize(id, restic.DataBlob)
+		if !ok {
+			return nil, fmt.Errorf("blob %v of %q not found", id.Str(), node.Name)
+		}
+		size += int64(blobSize)
+	}
+	return &blobFileReader{
+		ctx:     ctx,
+		repo:    repo,
+		content: node.Content,
+		offsets: offsets,
+		size:    size,
+		cached:  -1,
+	}, nil
+}
+
+// blobFileReader 按需加载文件的数据块
+type blobFileReader struct {
+	ctx     context.Context
+	repo    *repository.Repository
+	content restic.IDs
+	offsets []int64 //每个数据块在文件中的起始位置
+	size    int64
+	pos     int64
+	cached  int //当前缓存的数据块序号
+	buf     []byte
+}
+
+func (r *blobFileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	// 查找 pos 所在的数据块
+	i := sort.Search(len(r.offsets), func(i int) bool {
+		return r.offsets[i] > r.pos
+	}) - 1
+	if i != r.cached {
+		buf, err := r.repo.LoadBlob(r.ctx, restic.DataBlob, r.content[i], r.buf)
+		if err != nil {
+			return 0, err
+		}
+		r.buf = buf
+		r.cached = i
+	}
+	n := copy(p, r.buf[r.pos-r.offsets[i]:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *blobFileReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	r.pos = pos
+	return pos, nil
+}
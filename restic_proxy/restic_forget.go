@@ -81,10 +81,24 @@ type ForgetOptions struct {
 	Prune   bool // automatically run the 'prune' command if snapshots have been removed
 }
 
-func RunForget(opts ForgetOptions, repoid int, snapshotids []string) (int, error) {
+// defaultForgetHosts 未指定主机名时只清理本机的快照，手动清理、定时清理及预览使用相同规则
+func defaultForgetHosts(opts *ForgetOptions) error {
+	if len(opts.Hosts) > 0 {
+		return nil
+	}
 	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
 	opts.Hosts = []string{hostname}
+	return nil
+}
 
+func RunForget(opts ForgetOptions, repoid int, snapshotids []string) (int, error) {
+	err := defaultForgetHosts(&opts)
+	if err != nil {
+		return 0, err
+	}
 	repoHandler, err := GetRepository(repoid)
 	if err != nil {
 		return 0, err
@@ -97,14 +111,6 @@ func RunForget(opts ForgetOptions, repoid int, snapshotids []string) (int, error
 		cancel()
 	})
 
-	lock, err := lockRepoExclusive(ctx, repo)
-	if err != nil {
-		clean.Cleanup()
-		return 0, err
-	}
-	clean.AddCleanCtx(func() {
-		unlockRepo(lock)
-	})
 	status := repoModel.StatusNone
 	oper := operationModel.Operation{
 		RepositoryId: repoid,
@@ -123,6 +129,7 @@ func RunForget(opts ForgetOptions, repoid int, snapshotids []string) (int, error
 	spr := wsTaskInfo.NewSprintf(&logTask)
 
 	logTask.SetBound(make(chan string))
+	logTask.SetCancel(cancel)
 	log.LogInfos.Set(oper.Id, &logTask)
 	t.Go(func() error {
 		for {
@@ -138,9 +145,15 @@ func RunForget(opts ForgetOptions, repoid int, snapshotids []string) (int, error
 	})
 	t.Go(func() error {
 		defer clean.Cleanup()
-		err := forget(opts, ctx, repo, snapshotids, spr)
+		err := lockRepoExclusiveQueued(ctx, repoid, repo, clean, spr)
+		if err == nil {
+			err = forget(opts, ctx, repo, snapshotids, spr)
+		}
 		status = repoModel.StatusNone
-		if err != nil {
+		if logTask.IsCancelled() {
+			spr.Append(wsTaskInfo.Warning, "cancelled")
+			status = repoModel.StatusCancel
+		} else if err != nil {
 			spr.Append(wsTaskInfo.Error, err.Error())
 			status = repoModel.StatusErr
 		} else {
@@ -160,6 +173,10 @@ func RunForget(opts ForgetOptions, repoid int, snapshotids []string) (int, error
 }
 
 func RunForgetSync(opts ForgetOptions, repoid int, snapshotids []string) error {
+	err := defaultForgetHosts(&opts)
+	if err != nil {
+		return err
+	}
 	repoHandler, err := GetRepository(repoid)
 	if err != nil {
 		return err
@@ -167,14 +184,15 @@ func RunForgetSync(opts ForgetOptions, repoid int, snapshotids []string) error {
 	repo := repoHandler.repo
 
 	ctx := context.Background()
-	lock, err := lockRepoExclusive(ctx, repo)
-	defer unlockRepo(lock)
-	if err != nil {
-		return err
-	}
 	logTask := log.LogInfo{}
 	logTask.SetId(0)
 	spr := wsTaskInfo.NewSprintf(&logTask)
+	clean := NewCleanCtx()
+	defer clean.Cleanup()
+	err = lockRepoExclusiveQueued(ctx, repoid, repo, clean, spr)
+	if err != nil {
+		return err
+	}
 	err = forget(opts, ctx, repo, snapshotids, spr)
 	if err != nil {
 		return err
@@ -204,21 +222,7 @@ func forget(opts ForgetOptions, ctx context.Context, repo *repository.Repository
 			return err
 		}
 
-		policy := restic.ExpirePolicy{
-			Last:          int(opts.Last),
-			Hourly:        int(opts.Hourly),
-			Daily:         int(opts.Daily),
-			Weekly:        int(opts.Weekly),
-			Monthly:       int(opts.Monthly),
-			Yearly:        int(opts.Yearly),
-			Within:        opts.Within,
-			WithinHourly:  opts.WithinHourly,
-			WithinDaily:   opts.WithinDaily,
-			WithinWeekly:  opts.WithinWeekly,
-			WithinMonthly: opts.WithinMonthly,
-			WithinYearly:  opts.WithinYearly,
-			Tags:          opts.KeepTags,
-		}
+		policy := newExpirePolicy(opts)
 
 		if policy.Empty() && len(snapshotids) == 0 {
 			spr.Append(wsTaskInfo.Warning, fmt.Sprintf("no policy was specified, no snapshots will be removed\n"))
@@ -275,6 +279,25 @@ func forget(opts ForgetOptions, ctx context.Context, repo *repository.Repository
 	return nil
 }
 
+// newExpirePolicy 将清理参数中的所有保留规则合并为一个策略
+func newExpirePolicy(opts ForgetOptions) restic.ExpirePolicy {
+	return restic.ExpirePolicy{
+		Last:          int(opts.Last),
+		Hourly:        int(opts.Hourly),
+		Daily:         int(opts.Daily),
+		Weekly:        int(opts.Weekly),
+		Monthly:       int(opts.Monthly),
+		Yearly:        int(opts.Yearly),
+		Within:        opts.Within,
+		WithinHourly:  opts.WithinHourly,
+		WithinDaily:   opts.WithinDaily,
+		WithinWeekly:  opts.WithinWeekly,
+		WithinMonthly: opts.WithinMonthly,
+		WithinYearly:  opts.WithinYearly,
+		Tags:          opts.KeepTags,
+	}
+}
+
 // PrintSnapshots prints a text table of the snapshots in list to stdout.
 func PrintSnapshots(spr *wsTaskInfo.Sprintf, list restic.Snapshots, reasons []restic.KeepReason, compact bool) {
 	// keep the reasons a snasphot is being kept in a map, so that it doesn't
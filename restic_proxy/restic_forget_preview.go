@@ -0,0 +1,159 @@
+package resticProxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kubackup/kubackup/internal/store/log"
+	"github.com/kubackup/kubackup/internal/store/ws_task_info"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"sort"
+)
+
+// ForgetPreview 清理策略预览结果，不会修改存储库
+type ForgetPreview struct {
+	Groups      []ForgetPreviewGroup `json:"groups"`
+	KeepCount   int                  `json:"keepCount"`
+	RemoveCount int                  `json:"removeCount"`
+	// 预计清理后释放的空间，仅统计只被删除快照引用的数据，实际释放量受 prune 重新打包策略影响
+	FreedSize  uint64 `json:"freedSize"`
+	FreedBlobs int    `json:"freedBlobs"`
+}
+
+// ForgetPreviewGroup 按分组方式计算的单个快照分组
+type ForgetPreviewGroup struct {
+	GroupKey restic.SnapshotGroupKey `json:"group_key"`
+	Keep     []ForgetPreviewSnapshot `json:"keep"`
+	Remove   []ForgetPreviewSnapshot `json:"remove"`
+}
+
+// ForgetPreviewSnapshot 快照及保留原因
+type ForgetPreviewSnapshot struct {
+	SnapshotRes
+	Reasons []string `json:"reasons"`
+}
+
+// PreviewForget 计算清理策略将保留及删除的快照，并预估 prune 后释放的空间
+func PreviewForget(opts ForgetOptions, repoid int) (*ForgetPreview, error) {
+	err := defaultForgetHosts(&opts)
+	if err != nil {
+		return nil, err
+	}
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return nil, err
+	}
+	repo := repoHandler.repo
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clean := NewCleanCtx()
+	clean.AddCleanCtx(func() {
+		cancel()
+	})
+	defer clean.Cleanup()
+
+	policy := newExpirePolicy(opts)
+	if policy.Empty() {
+		return nil, fmt.Errorf("至少需要设置一条保留规则")
+	}
+
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, repo.Backend(), repo, &opts.SnapshotFilter, []string{}) {
+		snapshots = append(snapshots, sn)
+	}
+	snapshotGroups, grouped, err := restic.GroupSnapshots(snapshots, opts.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ForgetPreview{Groups: make([]ForgetPreviewGroup, 0)}
+	removeSnIDs := restic.NewIDSet()
+	var removeTrees restic.IDs
+	for k, snapshotGroup := range snapshotGroups {
+		var key restic.SnapshotGroupKey
+		if grouped {
+			err = json.Unmarshal([]byte(k), &key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		keep, remove, reasons := restic.ApplyPolicy(snapshotGroup, policy)
+		group := ForgetPreviewGroup{
+			GroupKey: key,
+			Keep:     make([]ForgetPreviewSnapshot, 0, len(keep)),
+			Remove:   make([]ForgetPreviewSnapshot, 0, len(remove)),
+		}
+		for i, sn := range keep {
+			group.Keep = append(group.Keep, newForgetPreviewSnapshot(sn, key, reasons[i].Matches))
+		}
+		for _, sn := range remove {
+			group.Remove = append(group.Remove, newForgetPreviewSnapshot(sn, key, nil))
+			removeSnIDs.Insert(*sn.ID())
+			removeTrees = append(removeTrees, *sn.Tree)
+		}
+		res.KeepCount += len(keep)
+		res.RemoveCount += len(remove)
+		res.Groups = append(res.Groups, group)
+	}
+	sort.SliceStable(res.Groups, func(i, j int) bool {
+		a, b := res.Groups[i].GroupKey, res.Groups[j].GroupKey
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+		return fmt.Sprint(a.Paths) < fmt.Sprint(b.Paths)
+	})
+
+	if len(removeTrees) > 0 {
+		res.FreedSize, res.FreedBlobs, err = estimateFreedSize(ctx, repo, removeSnIDs, removeTrees)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func newForgetPreviewSnapshot(sn *restic.Snapshot, key restic.SnapshotGroupKey, reasons []string) ForgetPreviewSnapshot {
+	if reasons == nil {
+		reasons = make([]string, 0)
+	}
+	return ForgetPreviewSnapshot{
+		SnapshotRes: SnapshotRes{
+			Snapshot: sn,
+			ID:       sn.ID(),
+			ShortID:  sn.ID().Str(),
+			GroupKey: key,
+		},
+		Reasons: reasons,
+	}
+}
+
+// estimateFreedSize 统计只被待删除快照引用的数据大小，其余快照（含未匹配过滤条件的快照）引用的数据均视为保留
+func estimateFreedSize(ctx context.Context, repo *repository.Repository, removeSnIDs restic.IDSet, removeTrees restic.IDs) (uint64, int, error) {
+	logTask := log.LogInfo{}
+	logTask.SetId(0)
+	spr := wsTaskInfo.NewSprintf(&logTask)
+	usedBlobs, err := getUsedBlobs(ctx, repo, removeSnIDs, spr)
+	if err != nil {
+		return 0, 0, err
+	}
+	removeBlobs := restic.NewBlobSet()
+	err = restic.FindUsedBlobs(ctx, repo, removeTrees, removeBlobs, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	var size uint64
+	var count int
+	for h := range removeBlobs {
+		if usedBlobs.Has(h) {
+			continue
+		}
+		pbs := repo.Index().Lookup(h)
+		if len(pbs) == 0 {
+			continue
+		}
+		size += uint64(pbs[0].Length)
+		count++
+	}
+	return size, count, nil
+}
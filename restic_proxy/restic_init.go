@@ -6,10 +6,12 @@ import (
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"github.com/restic/chunker"
 	"strconv"
 )
 
-func RunInit(ctx context.Context, gopts GlobalOptions) (version uint, error error) {
+// RunInit 初始化存储库，chunkerFrom 不为0时使用该存储库的分块参数
+func RunInit(ctx context.Context, gopts GlobalOptions, chunkerFrom int) (version uint, error error) {
 	repo, err := ReadRepo(gopts)
 	if err != nil {
 		return version, err
@@ -41,7 +43,15 @@ func RunInit(ctx context.Context, gopts GlobalOptions) (version uint, error erro
 		return version, errors.Fatalf("only repository versions between %v and %v are allowed", restic.MinRepoVersion, restic.MaxRepoVersion)
 	}
 
-	err = s.Init(ctx, version, gopts.password, nil)
+	var pol *chunker.Pol
+	if chunkerFrom > 0 {
+		pol, err = chunkerPolynomial(chunkerFrom)
+		if err != nil {
+			return version, err
+		}
+	}
+
+	err = s.Init(ctx, version, gopts.password, pol)
 	if err != nil {
 		return version, errors.Fatalf("create key in repository at %s failed: %v\n", location.StripPassword(gopts.backends, gopts.Repo), err)
 	}
@@ -0,0 +1,200 @@
+package resticProxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"github.com/kubackup/kubackup/internal/store/log"
+	wsTaskInfo "github.com/kubackup/kubackup/internal/store/ws_task_info"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"sort"
+	"sync"
+)
+
+// KeyInfo 存储库密钥
+type KeyInfo struct {
+	Current  bool   `json:"current"` //kubackup 当前使用的密钥
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	HostName string `json:"hostName"`
+	Created  string `json:"created"`
+}
+
+// ListKeys 列出存储库的所有密钥
+func ListKeys(repoid int) ([]KeyInfo, error) {
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return nil, err
+	}
+	repo := repoHandler.repo
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var m sync.Mutex
+	keys := make([]KeyInfo, 0)
+	err = restic.ParallelList(ctx, repo.Backend(), restic.KeyFile, repo.Connections(), func(ctx context.Context, id restic.ID, size int64) error {
+		k, err := repository.LoadKey(ctx, repo, id)
+		if err != nil {
+			return fmt.Errorf("LoadKey(%v) failed: %v", id.Str(), err)
+		}
+		m.Lock()
+		defer m.Unlock()
+		keys = append(keys, KeyInfo{
+			Current:  id == repo.KeyID(),
+			ID:       id.String(),
+			UserName: k.Username,
+			HostName: k.Hostname,
+			Created:  k.Created.Local().Format(TimeFormat),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created < keys[j].Created
+	})
+	return keys, nil
+}
+
+// AddKey 添加密钥，username、hostname 为空时使用当前用户及主机名，返回新密钥id
+func AddKey(repoid int, password, username, hostname string) (string, error) {
+	if password == "" {
+		return "", fmt.Errorf("请输入密码")
+	}
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return "", err
+	}
+	repo := repoHandler.repo
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lock, err := lockRepo(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	defer unlockRepo(lock)
+
+	id, err := addKey(ctx, repo, password, username, hostname)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// RemoveKey 删除密钥，不能删除 kubackup 当前使用的密钥，存储库上有任务时排队等待
+func RemoveKey(repoid int, keyid string) error {
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return err
+	}
+	repo := repoHandler.repo
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clean := NewCleanCtx()
+	defer clean.Cleanup()
+	err = lockKeyExclusive(ctx, repoid, repo, clean)
+	if err != nil {
+		return err
+	}
+
+	id, err := restic.Find(ctx, repo.Backend(), restic.KeyFile, keyid)
+	if err != nil {
+		return err
+	}
+	return removeKey(ctx, repo, id)
+}
+
+// RotateKey 更换 kubackup 使用的密钥：添加新密钥并校验，更新保存的密码后删除旧密钥。
+// 调用方需重新加载存储库，InitRepository 完成前内存中的存储库仍记录已删除的旧密钥id，
+// ListKeys 的 current 标记及 RemoveKey 的校验均以旧密钥为准
+func RotateKey(repoid int, password, username, hostname string) error {
+	if password == "" {
+		return fmt.Errorf("请输入密码")
+	}
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return err
+	}
+	repo := repoHandler.repo
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clean := NewCleanCtx()
+	defer clean.Cleanup()
+	err = lockKeyExclusive(ctx, repoid, repo, clean)
+	if err != nil {
+		return err
+	}
+
+	oldID := repo.KeyID()
+	newID, err := addKey(ctx, repo, password, username, hostname)
+	if err != nil {
+		return err
+	}
+	// 新密钥不可用或密码保存失败时删除新密钥，旧密钥保持不变
+	rollback := func(cause error) error {
+		h := restic.Handle{Type: restic.KeyFile, Name: newID.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return fmt.Errorf("%v，删除新密钥%s失败：%v", cause, newID.Str(), err)
+		}
+		return cause
+	}
+	err = verifyKey(ctx, repo, newID, password)
+	if err != nil {
+		return rollback(err)
+	}
+	err = repositoryService.UpdateField(repoid, "Password", password, common.DBOptions{})
+	if err != nil {
+		return rollback(err)
+	}
+	h := restic.Handle{Type: restic.KeyFile, Name: oldID.String()}
+	err = repo.Backend().Remove(ctx, h)
+	if err != nil {
+		return fmt.Errorf("已使用新密钥%s，删除旧密钥%s失败：%v", newID.Str(), oldID.Str(), err)
+	}
+	return nil
+}
+
+// lockKeyExclusive 与 prune 等操作一样在存储库队列中等待正在执行的任务结束后获取互斥锁
+func lockKeyExclusive(ctx context.Context, repoid int, repo *repository.Repository, clean *CleanCtx) error {
+	logTask := log.LogInfo{}
+	logTask.SetId(0)
+	return lockRepoExclusiveQueued(ctx, repoid, repo, clean, wsTaskInfo.NewSprintf(&logTask))
+}
+
+func addKey(ctx context.Context, repo *repository.Repository, password, username, hostname string) (restic.ID, error) {
+	key, err := repository.AddKey(ctx, repo, password, username, hostname, repo.Key())
+	if err != nil {
+		return restic.ID{}, errors.Fatalf("creating new key failed: %v\n", err)
+	}
+	return key.ID(), nil
+}
+
+func removeKey(ctx context.Context, repo *repository.Repository, id restic.ID) error {
+	if id == repo.KeyID() {
+		return errors.Fatal("refusing to remove key currently used to access repository")
+	}
+	h := restic.Handle{Type: restic.KeyFile, Name: id.String()}
+	return repo.Backend().Remove(ctx, h)
+}
+
+// verifyKey 使用新的存储库对象以新密码打开，不影响正在使用的存储库
+func verifyKey(ctx context.Context, repo *repository.Repository, id restic.ID, password string) error {
+	s, err := repository.New(repo.Backend(), repository.Options{})
+	if err != nil {
+		return err
+	}
+	err = s.SearchKey(ctx, password, 0, id.String())
+	if err != nil {
+		return errors.Fatalf("verifying new key failed: %v", err)
+	}
+	if s.Config().ID != repo.Config().ID {
+		return errors.Fatal("new key opens a different repository")
+	}
+	return nil
+}
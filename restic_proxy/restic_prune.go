@@ -171,14 +171,6 @@ func RunPrune(opts PruneOptions, repoid int) (int, error) {
 	clean.AddCleanCtx(func() {
 		cancel()
 	})
-	lock, err := lockRepoExclusive(ctx, repo)
-	if err != nil {
-		clean.Cleanup()
-		return 0, err
-	}
-	clean.AddCleanCtx(func() {
-		unlockRepo(lock)
-	})
 	status := repoModel.StatusNone
 	oper := operationModel.Operation{
 		RepositoryId: repoid,
@@ -197,6 +189,7 @@ func RunPrune(opts PruneOptions, repoid int) (int, error) {
 	spr := wsTaskInfo.NewSprintf(&logTask)
 
 	logTask.SetBound(make(chan string))
+	logTask.SetCancel(cancel)
 	log.LogInfos.Set(oper.Id, &logTask)
 	t.Go(func() error {
 		for {
@@ -213,15 +206,22 @@ func RunPrune(opts PruneOptions, repoid int) (int, error) {
 
 	t.Go(func() error {
 		defer clean.Cleanup()
-		err := runPruneWithRepo(opts, ctx, repo, restic.NewIDSet(), spr)
+		err := lockRepoExclusiveQueued(ctx, repoid, repo, clean, spr)
+		if err == nil {
+			err = runPruneWithRepo(opts, ctx, repo, restic.NewIDSet(), spr)
+		}
 		status = repoModel.StatusNone
-		if err != nil {
+		if logTask.IsCancelled() {
+			spr.Append(wsTaskInfo.Warning, "cancelled")
+			status = repoModel.StatusCancel
+		} else if err != nil {
 			spr.Append(wsTaskInfo.Error, err.Error())
 			status = repoModel.StatusErr
 		} else {
 			status = repoModel.StatusRun
 		}
-		err = repo.LoadIndex(ctx, nil)
+		// 任务取消后 ctx 已失效，仍需重新加载索引
+		err = repo.LoadIndex(context.Background(), nil)
 		if err != nil {
 			spr.Append(wsTaskInfo.Error, err.Error())
 		}
@@ -33,14 +33,6 @@ func RunRebuildIndex(opts RebuildIndexOptions, repoid int) (int, error) {
 		cancel()
 	})
 
-	lock, err := lockRepoExclusive(ctx, repo)
-	if err != nil {
-		clean.Cleanup()
-		return 0, err
-	}
-	clean.AddCleanCtx(func() {
-		unlockRepo(lock)
-	})
 	status := repoModel.StatusNone
 	oper := operationModel.Operation{
 		RepositoryId: repoid,
@@ -59,6 +51,7 @@ func RunRebuildIndex(opts RebuildIndexOptions, repoid int) (int, error) {
 	spr := wsTaskInfo.NewSprintf(&logTask)
 
 	logTask.SetBound(make(chan string))
+	logTask.SetCancel(cancel)
 	log.LogInfos.Set(oper.Id, &logTask)
 	t.Go(func() error {
 		for {
@@ -74,15 +67,22 @@ func RunRebuildIndex(opts RebuildIndexOptions, repoid int) (int, error) {
 	})
 	t.Go(func() error {
 		defer clean.Cleanup()
-		err := rebuildIndex(opts, ctx, repo, spr)
+		err := lockRepoExclusiveQueued(ctx, repoid, repo, clean, spr)
+		if err == nil {
+			err = rebuildIndex(opts, ctx, repo, spr)
+		}
 		status = repoModel.StatusNone
-		if err != nil {
+		if logTask.IsCancelled() {
+			spr.Append(wsTaskInfo.Warning, "cancelled")
+			status = repoModel.StatusCancel
+		} else if err != nil {
 			spr.Append(wsTaskInfo.Error, err.Error())
 			status = repoModel.StatusErr
 		} else {
 			status = repoModel.StatusRun
 		}
-		err = repo.LoadIndex(ctx, nil)
+		// 任务取消后 ctx 已失效，仍需重新加载索引
+		err = repo.LoadIndex(context.Background(), nil)
 		if err != nil {
 			spr.Append(wsTaskInfo.Error, err.Error())
 		}
@@ -15,6 +15,7 @@ import (
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restorer"
 	restoreui "github.com/kubackup/kubackup/pkg/restic_source/rinternal/ui/restore"
 	"gopkg.in/tomb.v2"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -28,13 +29,104 @@ type RestoreOptions struct {
 	InsensitiveInclude []string //same as `--include` but ignores the casing of filenames
 	Target             string   //directory to extract data to
 	restic.SnapshotFilter
-	Sparse bool //restore files as sparse
-	Verify bool //verify restored files content
+	Sparse    bool   //restore files as sparse
+	Verify    bool   //verify restored files content
+	Overwrite string //已有文件的处理方式，见 OverwriteAlways 等
+	Delete    bool   //删除目标目录中快照里不存在的文件
+	DryRun    bool   //仅计算将写入及覆盖的文件，不修改目标目录
+
+	info        model.RestoreInfo        //原始参数，保存到任务中用于继续还原
+	resumeOf    int                      //继续还原的原始任务id
+	resumeSince time.Time                //原始任务开始时间，之后修改的文件视为中断时未完成
+	checkpoint  *model.RestoreCheckpoint //中断的任务保存的还原进度
+}
+
+// NewRestoreOptions 将接口参数转换为还原选项，多个值以逗号分隔
+func NewRestoreOptions(info model.RestoreInfo) (RestoreOptions, error) {
+	target := info.Target
+	if target == "" {
+		target = string(filepath.Separator)
+	}
+	split := func(str string) []string {
+		if str == "" {
+			return nil
+		}
+		return strings.Split(str, ",")
+	}
+	tags := restic.TagLists{}
+	if info.Tags != "" {
+		err := tags.Set(info.Tags)
+		if err != nil {
+			return RestoreOptions{}, err
+		}
+	}
+	return RestoreOptions{
+		Exclude:            split(info.Exclude),
+		InsensitiveExclude: split(info.IExclude),
+		Include:            split(info.Include),
+		InsensitiveInclude: split(info.IInclude),
+		Target:             target,
+		SnapshotFilter:     restic.SnapshotFilter{Hosts: split(info.Hosts), Paths: split(info.Paths), Tags: tags},
+		Verify:             info.Verify,
+		Sparse:             info.Sparse,
+		Overwrite:          info.Overwrite,
+		Delete:             info.Delete,
+		DryRun:             info.DryRun,
+		info:               info,
+	}, nil
 }
 
-func RunRestore(opts RestoreOptions, repoid int, snapshotid string) error {
+// ResumeRestore 继续中断或取消的还原任务，已写入的文件不再写入，但仍恢复权限、属主及修改时间。
+// 中断时文件内容已全部写入的，按保存的进度直接跳过；否则按快照数据块重新计算已写入文件的哈希
+func ResumeRestore(taskid int) error {
+	ta, err := taskHistoryService.Get(taskid, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	if ta.Restore == nil || ta.SnapshotId == "" {
+		return fmt.Errorf("该任务不是可继续的还原任务")
+	}
+	if task.TaskInfos.Get(ta.Id) != nil {
+		return fmt.Errorf("还原任务正在执行")
+	}
+	switch ta.Status {
+	case task.StatusError, task.StatusCancel:
+	case task.StatusNew, task.StatusRunning, task.StatusQueued:
+		// 服务重启后中断的任务仍为执行中，先标记为失败
+		ta.Status = task.StatusError
+		ta.ArchivalError = append(ta.ArchivalError, model.ErrorUpdate{
+			MessageType: "error",
+			Error:       "任务中断",
+		})
+		err = taskHistoryService.Update(ta, common.DBOptions{})
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("只能继续中断、失败或已取消的还原任务")
+	}
+	root := ta
+	if ta.ResumeOf > 0 {
+		root, err = taskHistoryService.Get(ta.ResumeOf, common.DBOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	opts, err := NewRestoreOptions(*ta.Restore)
+	if err != nil {
+		return err
+	}
+	opts.DryRun = false
+	opts.resumeOf = root.Id
+	opts.resumeSince = root.CreatedAt
+	opts.checkpoint = ta.Checkpoint
+	_, err = RunRestore(opts, ta.RepositoryId, ta.SnapshotId)
+	return err
+}
+
+func RunRestore(opts RestoreOptions, repoid int, snapshotid string) (*RestoreReport, error) {
 	if snapshotid == "" {
-		return errors.Fatal("snapshotid不能为空")
+		return nil, errors.Fatal("snapshotid不能为空")
 	}
 	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0
 	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0
@@ -42,22 +134,22 @@ func RunRestore(opts RestoreOptions, repoid int, snapshotid string) error {
 	// Validate provided patterns
 	if len(opts.Exclude) > 0 {
 		if err := filter.ValidatePatterns(opts.Exclude); err != nil {
-			return errors.Fatalf("--exclude: %s", err)
+			return nil, errors.Fatalf("--exclude: %s", err)
 		}
 	}
 	if len(opts.InsensitiveExclude) > 0 {
 		if err := filter.ValidatePatterns(opts.InsensitiveExclude); err != nil {
-			return errors.Fatalf("--iexclude: %s", err)
+			return nil, errors.Fatalf("--iexclude: %s", err)
 		}
 	}
 	if len(opts.Include) > 0 {
 		if err := filter.ValidatePatterns(opts.Include); err != nil {
-			return errors.Fatalf("--include: %s", err)
+			return nil, errors.Fatalf("--include: %s", err)
 		}
 	}
 	if len(opts.InsensitiveInclude) > 0 {
 		if err := filter.ValidatePatterns(opts.InsensitiveInclude); err != nil {
-			return errors.Fatalf("--iinclude: %s", err)
+			return nil, errors.Fatalf("--iinclude: %s", err)
 		}
 	}
 
@@ -69,18 +161,28 @@ func RunRestore(opts RestoreOptions, repoid int, snapshotid string) error {
 		opts.InsensitiveInclude[i] = strings.ToLower(str)
 	}
 	if opts.Target == "" {
-		return errors.Fatal("please specify a directory to restore to (--target)")
+		return nil, errors.Fatal("please specify a directory to restore to (--target)")
 	}
 
 	if hasExcludes && hasIncludes {
-		return errors.Fatal("exclude and include patterns are mutually exclusive")
+		return nil, errors.Fatal("exclude and include patterns are mutually exclusive")
+	}
+	overwrite, err := CheckOverwrite(opts.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Delete && filepath.Dir(filepath.Clean(opts.Target)) == filepath.Clean(opts.Target) {
+		return nil, errors.Fatal("不能在根目录中删除多余文件，请指定还原目录")
 	}
 	server.Logger().Debugf("restore %s to %s", snapshotid, opts.Target)
 	repoHandler, err := GetRepository(repoid)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	repo := repoHandler.repo
+	if opts.DryRun {
+		return restoreDryRun(opts, overwrite, repo, snapshotid)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	clean := NewCleanCtx()
@@ -88,9 +190,9 @@ func RunRestore(opts RestoreOptions, repoid int, snapshotid string) error {
 		cancel()
 	})
 
-	ta, err := createRestoreTask(opts.Target, repoid)
+	ta, err := createRestoreTask(opts, repoid, snapshotid)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var t tomb.Tomb
@@ -110,70 +212,29 @@ func RunRestore(opts RestoreOptions, repoid int, snapshotid string) error {
 		Tags:  opts.Tags,
 	}).FindLatest(ctx, repo.Backend(), repo, snapshotid)
 	if err != nil {
-		return errors.Fatalf("failed to find snapshot: %v", err)
+		return nil, errors.Fatalf("failed to find snapshot: %v", err)
 	}
 
 	sn.Tree, err = restic.FindTreeDirectory(ctx, repo, sn.Tree, subfolder)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	res := restorer.NewRestorer(repo, sn, opts.Sparse, progressReporter)
 
 	res.Error = printer.Error
 
-	excludePatterns := filter.ParsePatterns(opts.Exclude)
-	insensitiveExcludePatterns := filter.ParsePatterns(opts.InsensitiveExclude)
-	selectExcludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
-		matched, err := filter.List(excludePatterns, item)
-		if err != nil {
-			server.Logger().Warnf("error for exclude pattern: %v", err)
-		}
-
-		matchedInsensitive, err := filter.List(insensitiveExcludePatterns, strings.ToLower(item))
-		if err != nil {
-			server.Logger().Warnf("error for iexclude pattern: %v", err)
-		}
-
-		// An exclude filter is basically a 'wildcard but foo',
-		// so even if a childMayMatch, other children of a dir may not,
-		// therefore childMayMatch does not matter, but we should not go down
-		// unless the dir is selected for restore
-		selectedForRestore = !matched && !matchedInsensitive
-		childMayBeSelected = selectedForRestore && node.Type == "dir"
-
-		return selectedForRestore, childMayBeSelected
-	}
-
-	includePatterns := filter.ParsePatterns(opts.Include)
-	insensitiveIncludePatterns := filter.ParsePatterns(opts.InsensitiveInclude)
-	selectIncludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
-		matched, childMayMatch, err := filter.ListWithChild(includePatterns, item)
-		if err != nil {
-			server.Logger().Warnf("error for include pattern: %v", err)
-		}
-
-		matchedInsensitive, childMayMatchInsensitive, err := filter.ListWithChild(insensitiveIncludePatterns, strings.ToLower(item))
-		if err != nil {
-			server.Logger().Warnf("error for iexclude pattern: %v", err)
-		}
-
-		selectedForRestore = matched || matchedInsensitive
-		childMayBeSelected = (childMayMatch || childMayMatchInsensitive) && node.Type == "dir"
-
-		return selectedForRestore, childMayBeSelected
-	}
-
-	if hasExcludes {
-		res.SelectFilter = selectExcludeFilter
-	} else if hasIncludes {
-		res.SelectFilter = selectIncludeFilter
-	}
+	selector := newRestoreSelector(repo, overwrite, newRestoreFilter(opts), printer)
+	selector.taskid = ta.Id
+	selector.resumeSince = opts.resumeSince
+	selector.resume = opts.checkpoint
+	res.SelectFilter = selector.Select
 
 	server.Logger().Debugf("restoring %s to %s\n", res.Snapshot().ID().Str(), opts.Target)
 	taskinfoid := ta.Id
 	bound := make(chan string)
 	taskInfo.SetBound(bound)
+	taskInfo.SetCancel(cancel)
 	task.TaskInfos.Set(taskInfo.GetId(), &taskInfo)
 	t.Go(func() error {
 		for {
@@ -193,11 +254,18 @@ func RunRestore(opts RestoreOptions, repoid int, snapshotid string) error {
 			server.Logger().Error(err)
 		}
 		err = res.RestoreTo(ctx, opts.Target)
-		if err != nil {
+		selector.endRestore(err != nil)
+		if err != nil && !taskInfo.IsCancelled() {
 			server.Logger().Error(err)
 			_ = printer.Error("RestoreTo", err)
 		}
-		if opts.Verify {
+		if err == nil && opts.Delete && !taskInfo.IsCancelled() {
+			err = selector.deleteExtraneous(ctx, *sn.Tree, opts.Target)
+			if err != nil && !taskInfo.IsCancelled() {
+				_ = printer.Error("DeleteExtraneous", err)
+			}
+		}
+		if opts.Verify && !taskInfo.IsCancelled() {
 			server.Logger().Debugf("verifying files in %s\n", opts.Target)
 			t0 := time.Now()
 			count, err := res.VerifyFiles(ctx, opts.Target)
@@ -214,11 +282,64 @@ func RunRestore(opts RestoreOptions, repoid int, snapshotid string) error {
 		}
 		progressReporter.Finish()
 	}()
-	return nil
+	return nil, nil
+
+}
+
+// newRestoreFilter 根据包含/排除规则生成选择函数，未设置规则时返回 nil
+func newRestoreFilter(opts RestoreOptions) selectFunc {
+	excludePatterns := filter.ParsePatterns(opts.Exclude)
+	insensitiveExcludePatterns := filter.ParsePatterns(opts.InsensitiveExclude)
+	selectExcludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+		matched, err := filter.List(excludePatterns, item)
+		if err != nil {
+			server.Logger().Warnf("error for exclude pattern: %v", err)
+		}
+
+		matchedInsensitive, err := filter.List(insensitiveExcludePatterns, strings.ToLower(item))
+		if err != nil {
+			server.Logger().Warnf("error for iexclude pattern: %v", err)
+		}
 
+		// An exclude filter is basically a 'wildcard but foo',
+		// so even if a childMayMatch, other children of a dir may not,
+		// therefore childMayMatch does not matter, but we should not go down
+		// unless the dir is selected for restore
+		selectedForRestore = !matched && !matchedInsensitive
+		childMayBeSelected = selectedForRestore && node.Type == "dir"
+
+		return selectedForRestore, childMayBeSelected
+	}
+
+	includePatterns := filter.ParsePatterns(opts.Include)
+	insensitiveIncludePatterns := filter.ParsePatterns(opts.InsensitiveInclude)
+	selectIncludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+		matched, childMayMatch, err := filter.ListWithChild(includePatterns, item)
+		if err != nil {
+			server.Logger().Warnf("error for include pattern: %v", err)
+		}
+
+		matchedInsensitive, childMayMatchInsensitive, err := filter.ListWithChild(insensitiveIncludePatterns, strings.ToLower(item))
+		if err != nil {
+			server.Logger().Warnf("error for iexclude pattern: %v", err)
+		}
+
+		selectedForRestore = matched || matchedInsensitive
+		childMayBeSelected = (childMayMatch || childMayMatchInsensitive) && node.Type == "dir"
+
+		return selectedForRestore, childMayBeSelected
+	}
+
+	if len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0 {
+		return selectExcludeFilter
+	}
+	if len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0 {
+		return selectIncludeFilter
+	}
+	return nil
 }
 
-func createRestoreTask(target string, repository int) (*thmodel.Task, error) {
+func createRestoreTask(opts RestoreOptions, repository int, snapshotid string) (*thmodel.Task, error) {
 	progress := &model.StatusUpdate{
 		MessageType:      "status",
 		SecondsElapsed:   "0",
@@ -230,12 +351,16 @@ func createRestoreTask(target string, repository int) (*thmodel.Task, error) {
 		ErrorCount:       0,
 		PercentDone:      0,
 	}
+	info := opts.info
 	t := &thmodel.Task{
-		Path:         target,
+		Path:         opts.Target,
 		Name:         "restore_" + strconv.Itoa(repository) + "_" + time.Now().Format(consts.TaskHistoryName),
 		Status:       task.StatusNew,
 		RepositoryId: repository,
 		Progress:     progress,
+		SnapshotId:   snapshotid,
+		Restore:      &info,
+		ResumeOf:     opts.resumeOf,
 	}
 	err := taskHistoryService.Create(t, common.DBOptions{})
 	if err != nil {
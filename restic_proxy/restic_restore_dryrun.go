@@ -0,0 +1,184 @@
+package resticProxy
+
+import (
+	"context"
+	fileutil "github.com/kubackup/kubackup/pkg/file"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// RestoreReport 还原预演结果，不会修改目标目录
+type RestoreReport struct {
+	SnapshotID     string            `json:"snapshotId"`
+	Target         string            `json:"target"`
+	Overwrite      string            `json:"overwrite"`
+	Files          uint64            `json:"files"`          //将写入的文件数，包含覆盖的文件
+	Dirs           uint64            `json:"dirs"`           //将还原的目录数
+	Bytes          uint64            `json:"bytes"`          //将写入的数据量
+	OverwriteCount uint64            `json:"overwriteCount"` //将覆盖的已有文件数
+	SkipCount      uint64            `json:"skipCount"`      //将跳过的已有文件数
+	DeleteCount    uint64            `json:"deleteCount"`    //将删除的多余文件数
+	Conflicts      []RestoreConflict `json:"conflicts"`      //已有文件的处理，最多 maxRestoreLogs 条
+	// 预计需要的空间，覆盖时扣除原文件大小；删除在还原完成后进行，不计入
+	RequiredBytes uint64 `json:"requiredBytes"`
+	FreeBytes     uint64 `json:"freeBytes"`   //目标文件系统可用空间
+	EnoughSpace   bool   `json:"enoughSpace"` //可用空间是否足够
+}
+
+// RestoreConflict 目标目录中已存在的文件及处理方式
+type RestoreConflict struct {
+	Item         string `json:"item"`
+	Action       string `json:"action"` // overwrite/skip/delete
+	Reason       string `json:"reason"`
+	Size         uint64 `json:"size"`         //快照中的文件大小
+	ExistingSize uint64 `json:"existingSize"` //已有文件大小
+}
+
+type restoreDryRunner struct {
+	selector *restoreSelector
+	report   *RestoreReport
+	// 硬链接只写入一次数据
+	hardlinks map[[2]uint64]struct{}
+}
+
+// restoreDryRun 按还原时相同的规则遍历快照，统计将写入、覆盖及删除的文件
+func restoreDryRun(opts RestoreOptions, overwrite string, repo *repository.Repository, snapshotid string) (*RestoreReport, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clean := NewCleanCtx()
+	clean.AddCleanCtx(func() {
+		cancel()
+	})
+	defer clean.Cleanup()
+
+	sn, subfolder, err := (&restic.SnapshotFilter{
+		Hosts: opts.Hosts,
+		Paths: opts.Paths,
+		Tags:  opts.Tags,
+	}).FindLatest(ctx, repo.Backend(), repo, snapshotid)
+	if err != nil {
+		return nil, errors.Fatalf("failed to find snapshot: %v", err)
+	}
+	sn.Tree, err = restic.FindTreeDirectory(ctx, repo, sn.Tree, subfolder)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &restoreDryRunner{
+		selector: newRestoreSelector(repo, overwrite, newRestoreFilter(opts), nil),
+		report: &RestoreReport{
+			SnapshotID: sn.ID().String(),
+			Target:     opts.Target,
+			Overwrite:  overwrite,
+			Conflicts:  make([]RestoreConflict, 0),
+		},
+		hardlinks: make(map[[2]uint64]struct{}),
+	}
+	err = d.walk(ctx, *sn.Tree, opts.Target, "/")
+	if err != nil {
+		return nil, err
+	}
+	if opts.Delete {
+		err = d.selector.walkExtraneous(ctx, *sn.Tree, opts.Target, "/", func(item string, dstpath string, entry os.DirEntry) {
+			d.report.DeleteCount++
+			conflict := RestoreConflict{Item: item, Action: RestoreActionDelete, Reason: "not in snapshot"}
+			if fi, err := entry.Info(); err == nil && fi.Mode().IsRegular() {
+				conflict.ExistingSize = uint64(fi.Size())
+			}
+			d.addConflict(conflict)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d.report.FreeBytes, err = fileutil.FreeSpace(opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	d.report.EnoughSpace = d.report.RequiredBytes <= d.report.FreeBytes
+	return d.report, nil
+}
+
+// walk 与还原时一样，目录被选中或其子项可能被选中时才进入
+func (d *restoreDryRunner) walk(ctx context.Context, treeID restic.ID, dst string, location string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	tree, err := restic.LoadTree(ctx, d.selector.repo, treeID)
+	if err != nil {
+		return err
+	}
+	for _, node := range tree.Nodes {
+		item := path.Join(location, node.Name)
+		dstpath := filepath.Join(dst, node.Name)
+		selected, childMayBeSelected := d.selector.selectBase(item, dstpath, node)
+		if node.Type == "dir" {
+			if selected {
+				d.report.Dirs++
+			}
+			if (selected || childMayBeSelected) && node.Subtree != nil {
+				err = d.walk(ctx, *node.Subtree, dstpath, item)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !selected {
+			continue
+		}
+		d.visitNode(item, dstpath, node)
+	}
+	return nil
+}
+
+func (d *restoreDryRunner) visitNode(item string, dstpath string, node *restic.Node) {
+	size := node.Size
+	if node.Type != "file" {
+		size = 0
+	} else if node.Links > 1 {
+		key := [2]uint64{node.Inode, node.DeviceID}
+		if _, ok := d.hardlinks[key]; ok {
+			size = 0
+		}
+		d.hardlinks[key] = struct{}{}
+	}
+
+	fi, err := os.Lstat(dstpath)
+	if err != nil {
+		d.report.Files++
+		d.report.Bytes += size
+		d.report.RequiredBytes += size
+		return
+	}
+	var existing uint64
+	if fi.Mode().IsRegular() {
+		existing = uint64(fi.Size())
+	}
+	restore, reason, _ := d.selector.overwriteDecision(dstpath, fi, node)
+	conflict := RestoreConflict{Item: item, Reason: reason, Size: node.Size, ExistingSize: existing}
+	if restore {
+		conflict.Action = RestoreActionOverwrite
+		d.report.OverwriteCount++
+		d.report.Files++
+		d.report.Bytes += size
+		if size > existing {
+			d.report.RequiredBytes += size - existing
+		}
+	} else {
+		conflict.Action = RestoreActionSkip
+		d.report.SkipCount++
+	}
+	d.addConflict(conflict)
+}
+
+func (d *restoreDryRunner) addConflict(conflict RestoreConflict) {
+	if len(d.report.Conflicts) >= maxRestoreLogs {
+		return
+	}
+	d.report.Conflicts = append(d.report.Conflicts, conflict)
+}
@@ -0,0 +1,356 @@
+package resticProxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubackup/kubackup/internal/model"
+	"github.com/kubackup/kubackup/internal/server"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 已有文件的处理方式
+const (
+	OverwriteAlways    = "always"     //总是覆盖
+	OverwriteIfChanged = "if-changed" //内容不同时覆盖
+	OverwriteIfNewer   = "if-newer"   //快照中的文件较新时覆盖
+	OverwriteNever     = "never"      //不覆盖
+)
+
+// 对已有文件的处理结果
+const (
+	RestoreActionOverwrite = "overwrite"
+	RestoreActionSkip      = "skip"
+	RestoreActionDelete    = "delete"
+)
+
+// maxRestoreLogs 单个任务最多保存的处理记录数
+const maxRestoreLogs = 1000
+
+// CheckOverwrite 校验覆盖方式，为空时使用 always
+func CheckOverwrite(mode string) (string, error) {
+	switch mode {
+	case "":
+		return OverwriteAlways, nil
+	case OverwriteAlways, OverwriteIfChanged, OverwriteIfNewer, OverwriteNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("不支持的覆盖方式：%s", mode)
+}
+
+type selectFunc func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+// restoreSelector 在包含/排除规则的基础上，按覆盖方式决定是否还原已有文件
+type restoreSelector struct {
+	repo    *repository.Repository
+	mode    string
+	base    selectFunc //包含/排除规则，为空时选择所有文件
+	printer *restorePrinter
+	lock    sync.Mutex
+	// 还原过程会多次遍历快照，第二次遍历时文件已被写入，需沿用首次的判断结果
+	decided map[string]restoreDecision
+	// 继续还原时为原始任务开始时间
+	resumeSince time.Time
+	// 继续还原时为中断的任务保存的进度
+	resume *model.RestoreCheckpoint
+	// 本次还原的进度，定期保存到任务中
+	taskid   int
+	progress model.RestoreCheckpoint
+	lastSave time.Time
+	finished bool
+}
+
+// restoreDecision 首次遍历时对文件的判断结果
+type restoreDecision int
+
+const (
+	decisionRestore  restoreDecision = iota + 1 //写入文件并恢复元数据
+	decisionSkip                                //不处理
+	decisionMetadata                            //之前的还原已写入，只恢复元数据
+)
+
+func newRestoreSelector(repo *repository.Repository, mode string, base selectFunc, printer *restorePrinter) *restoreSelector {
+	return &restoreSelector{
+		repo:    repo,
+		mode:    mode,
+		base:    base,
+		printer: printer,
+		decided: make(map[string]restoreDecision),
+	}
+}
+
+func (s *restoreSelector) selectBase(item string, dstpath string, node *restic.Node) (bool, bool) {
+	if s.base == nil {
+		return true, node.Type == "dir"
+	}
+	return s.base(item, dstpath, node)
+}
+
+func (s *restoreSelector) Select(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+	selectedForRestore, childMayBeSelected = s.selectBase(item, dstpath, node)
+	if !selectedForRestore || node.Type == "dir" {
+		return selectedForRestore, childMayBeSelected
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	d, ok := s.decided[item]
+	if !ok {
+		d = s.decide(item, dstpath, node)
+		s.decided[item] = d
+		return d == decisionRestore, childMayBeSelected
+	}
+	// 再次遍历时文件内容已全部写入，正在恢复元数据
+	s.checkpoint(item)
+	return d != decisionSkip, childMayBeSelected
+}
+
+// decide 目标不存在时直接还原，存在时按覆盖方式判断并记录
+func (s *restoreSelector) decide(item string, dstpath string, node *restic.Node) restoreDecision {
+	fi, err := os.Lstat(dstpath)
+	if err != nil {
+		return decisionRestore
+	}
+	var d restoreDecision
+	var reason string
+	if !s.resumeSince.IsZero() && node.Type == "file" {
+		d, reason = s.resumeDecision(item, dstpath, fi, node)
+	}
+	if d == 0 {
+		var restore bool
+		restore, reason, err = s.overwriteDecision(dstpath, fi, node)
+		if err != nil {
+			_ = s.printer.Error(item, err)
+		}
+		d = decisionSkip
+		if restore {
+			d = decisionRestore
+		}
+	}
+	if d != decisionRestore {
+		s.printer.restoreLog(RestoreActionSkip, item, reason)
+		return d
+	}
+	// 已有的链接、目录等无法直接覆盖，先删除
+	if node.Type != "file" || !fi.Mode().IsRegular() {
+		err = os.RemoveAll(dstpath)
+		if err != nil {
+			_ = s.printer.Error(item, err)
+		}
+	}
+	s.printer.restoreLog(RestoreActionOverwrite, item, reason)
+	return d
+}
+
+// resumeDecision 继续还原时判断之前的还原写入的文件是否已完成，返回0时按覆盖方式判断
+func (s *restoreSelector) resumeDecision(item string, dstpath string, fi os.FileInfo, node *restic.Node) (restoreDecision, string) {
+	// 修改时间在原始任务开始之后或已恢复为快照中时间的文件由之前的还原写入
+	if fi.ModTime().Before(s.resumeSince) && !fi.ModTime().Equal(node.ModTime) {
+		return 0, ""
+	}
+	if !fi.Mode().IsRegular() || uint64(fi.Size()) != node.Size {
+		return decisionRestore, "incomplete"
+	}
+	if s.resume != nil && s.resume.ContentDone {
+		if pathBefore(item, s.resume.Cursor) {
+			return decisionSkip, "already restored"
+		}
+		return decisionMetadata, "already restored"
+	}
+	changed, err := fileContentChanged(s.repo, dstpath, node)
+	if err == nil && !changed {
+		return decisionMetadata, "already restored"
+	}
+	return decisionRestore, "incomplete"
+}
+
+// checkpoint 记录恢复元数据的位置，首次记录及每隔一段时间保存到任务中
+func (s *restoreSelector) checkpoint(item string) {
+	if s.taskid == 0 || s.finished {
+		return
+	}
+	first := !s.progress.ContentDone
+	s.progress.ContentDone = true
+	s.progress.Cursor = item
+	if first || time.Since(s.lastSave) >= restoreProgressSaveInterval {
+		s.saveCheckpoint()
+	}
+}
+
+func (s *restoreSelector) saveCheckpoint() {
+	s.lastSave = time.Now()
+	checkpoint := s.progress
+	err := taskHistoryService.UpdateField(s.taskid, "Checkpoint", &checkpoint, common.DBOptions{})
+	if err != nil {
+		server.Logger().Error(err)
+	}
+}
+
+// endRestore 还原结束，之后的校验不再记录进度；中断时保存最后的位置
+func (s *restoreSelector) endRestore(interrupted bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if interrupted && s.taskid > 0 && s.progress.ContentDone {
+		s.saveCheckpoint()
+	}
+	s.finished = true
+}
+
+// pathBefore 按遍历快照的顺序判断 a 是否在 b 之前，同一目录下按名称排序
+func pathBefore(a, b string) bool {
+	if b == "" {
+		return false
+	}
+	as := strings.Split(strings.Trim(filepath.ToSlash(a), "/"), "/")
+	bs := strings.Split(strings.Trim(filepath.ToSlash(b), "/"), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// overwriteDecision 按覆盖方式判断是否覆盖已有文件，不修改目标目录；比较出错时覆盖
+func (s *restoreSelector) overwriteDecision(dstpath string, fi os.FileInfo, node *restic.Node) (bool, string, error) {
+	switch s.mode {
+	case OverwriteNever:
+		return false, "exists", nil
+	case OverwriteIfNewer:
+		if node.ModTime.After(fi.ModTime()) {
+			return true, "snapshot is newer", nil
+		}
+		return false, "target is newer or same age", nil
+	case OverwriteIfChanged:
+		changed, err := s.changed(dstpath, fi, node)
+		if changed || err != nil {
+			return true, "content changed", err
+		}
+		return false, "content unchanged", nil
+	}
+	return true, "exists", nil
+}
+
+// changed 比较已有文件与快照中的文件，大小及修改时间相同时视为未变化，否则按数据块校验内容
+func (s *restoreSelector) changed(dstpath string, fi os.FileInfo, node *restic.Node) (bool, error) {
+	switch node.Type {
+	case "file":
+		if !fi.Mode().IsRegular() || uint64(fi.Size()) != node.Size {
+			return true, nil
+		}
+		if fi.ModTime().Equal(node.ModTime) {
+			return false, nil
+		}
+		return fileContentChanged(s.repo, dstpath, node)
+	case "symlink":
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return true, nil
+		}
+		target, err := os.Readlink(dstpath)
+		if err != nil {
+			return true, err
+		}
+		return target != node.LinkTarget, nil
+	}
+	return true, nil
+}
+
+// fileContentChanged 按快照中的数据块大小分段读取文件，逐块比较哈希
+func fileContentChanged(repo *repository.Repository, p string, node *restic.Node) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return true, err
+	}
+	defer f.Close()
+	var buf []byte
+	for _, id := range node.Content {
+		size, ok := repo.LookupBlobSize(id, restic.DataBlob)
+		if !ok {
+			return true, fmt.Errorf("blob %v not found", id.Str())
+		}
+		if cap(buf) < int(size) {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		_, err = io.ReadFull(f, buf)
+		if err != nil {
+			return true, nil
+		}
+		if !restic.Hash(buf).Equal(id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteExtraneous 删除目标目录中快照里不存在的文件，仅处理包含/排除规则选中的路径
+func (s *restoreSelector) deleteExtraneous(ctx context.Context, treeID restic.ID, dst string) error {
+	return s.walkExtraneous(ctx, treeID, dst, "/", func(item string, dstpath string, _ os.DirEntry) {
+		err := os.RemoveAll(dstpath)
+		if err != nil {
+			_ = s.printer.Error(item, err)
+			return
+		}
+		s.printer.restoreLog(RestoreActionDelete, item, "not in snapshot")
+	})
+}
+
+// walkExtraneous 遍历目标目录中快照里不存在且被规则选中的文件
+func (s *restoreSelector) walkExtraneous(ctx context.Context, treeID restic.ID, dst string, location string, fn func(item string, dstpath string, entry os.DirEntry)) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	tree, err := restic.LoadTree(ctx, s.repo, treeID)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	nodes := make(map[string]*restic.Node, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		nodes[node.Name] = node
+	}
+	for _, entry := range entries {
+		if _, ok := nodes[entry.Name()]; ok {
+			continue
+		}
+		item := path.Join(location, entry.Name())
+		dstpath := filepath.Join(dst, entry.Name())
+		local := &restic.Node{Name: entry.Name(), Type: "file"}
+		if entry.IsDir() {
+			local.Type = "dir"
+		}
+		if selected, _ := s.selectBase(item, dstpath, local); !selected {
+			continue
+		}
+		fn(item, dstpath, entry)
+	}
+	for _, node := range tree.Nodes {
+		if node.Type != "dir" || node.Subtree == nil {
+			continue
+		}
+		item := path.Join(location, node.Name)
+		dstpath := filepath.Join(dst, node.Name)
+		selected, childMayBeSelected := s.selectBase(item, dstpath, node)
+		if !selected && !childMayBeSelected {
+			continue
+		}
+		err = s.walkExtraneous(ctx, *node.Subtree, dstpath, item, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
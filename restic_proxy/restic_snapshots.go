@@ -3,6 +3,7 @@ package resticProxy
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
 	"github.com/wxnacy/wgo/arrays"
 	"sort"
@@ -165,3 +166,22 @@ func newFilterLastSnapshotsKey(sn *restic.Snapshot) filterLastSnapshotsKey {
 	sort.Strings(paths)
 	return filterLastSnapshotsKey{sn.Hostname, strings.Join(paths, "|")}
 }
+
+// SplitSnapshotGroupBy 解析分组方式，如 host,paths,tags
+func SplitSnapshotGroupBy(s string) (restic.SnapshotGroupByOptions, error) {
+	var l restic.SnapshotGroupByOptions
+	for _, option := range strings.Split(s, ",") {
+		switch option {
+		case "host", "hosts":
+			l.Host = true
+		case "path", "paths":
+			l.Path = true
+		case "tag", "tags":
+			l.Tag = true
+		case "":
+		default:
+			return restic.SnapshotGroupByOptions{}, fmt.Errorf("unknown grouping option: %q", option)
+		}
+	}
+	return l, nil
+}
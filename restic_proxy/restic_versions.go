@@ -0,0 +1,154 @@
+package resticProxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/errors"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileVersion 文件的一个版本，内容未变化的连续快照合并为一个版本
+type FileVersion struct {
+	SnapshotID     string      `json:"snapshotId"`     //该版本首次出现的快照
+	ShortID        string      `json:"short_id"`       //该版本首次出现的快照短id
+	Time           time.Time   `json:"time"`           //该版本首次出现的快照时间
+	LastSnapshotID string      `json:"lastSnapshotId"` //该版本最后出现的快照
+	LastTime       time.Time   `json:"lastTime"`       //该版本最后出现的快照时间
+	Snapshots      int         `json:"snapshots"`      //包含该版本的快照数量
+	Type           string      `json:"type"`
+	Size           uint64      `json:"size"`
+	Mode           os.FileMode `json:"mode"`
+	ModTime        time.Time   `json:"mtime"`
+	ContentHash    string      `json:"contentHash"` //按数据块id计算的内容摘要，相同内容摘要相同
+}
+
+// RunFileVersions 列出文件在存储库所有快照中的版本，按时间倒序。
+// host 不为空时只查找该主机的快照
+func RunFileVersions(repoid int, filePath string, host string) ([]interface{}, error) {
+	filePath = path.Clean("/" + filePath)
+	if filePath == "/" {
+		return nil, errors.Errorf("no file path specified")
+	}
+	repoHandler, err := GetRepository(repoid)
+	if err != nil {
+		return nil, err
+	}
+	repo := repoHandler.repo
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clean := NewCleanCtx()
+	clean.AddCleanCtx(func() {
+		cancel()
+	})
+	defer clean.Cleanup()
+
+	filter := &restic.SnapshotFilter{}
+	if host != "" {
+		filter.Hosts = []string{host}
+	}
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, repo.Backend(), repo, filter, []string{}) {
+		snapshots = append(snapshots, sn)
+	}
+	sort.Sort(snapshots)
+
+	dir, name := path.Split(filePath)
+	// 多个快照的目录未变化时树id相同，缓存查找结果
+	nodes := make(map[restic.ID]*restic.Node)
+	versions := make([]*FileVersion, 0)
+	var last *FileVersion
+	for _, sn := range snapshots {
+		// 其他计划或主机的快照不包含该路径，跳过，避免打断未变化版本的合并
+		if !snapshotCoversPath(sn, filePath) {
+			continue
+		}
+		treeID, err := restic.FindTreeDirectory(ctx, repo, sn.Tree, dir)
+		if err != nil || treeID == nil {
+			// 文件在该快照中不存在，之后再出现时视为新版本
+			last = nil
+			continue
+		}
+		node, ok := nodes[*treeID]
+		if !ok {
+			tree, err := restic.LoadTree(ctx, repo, *treeID)
+			if err != nil {
+				return nil, fmt.Errorf("loading tree %v: %v", treeID.Str(), err)
+			}
+			for _, n := range tree.Nodes {
+				if n.Name == name {
+					node = n
+					break
+				}
+			}
+			nodes[*treeID] = node
+		}
+		if node == nil {
+			last = nil
+			continue
+		}
+		hash := nodeContentHash(node)
+		if last != nil && last.ContentHash == hash && last.Type == node.Type {
+			last.LastSnapshotID = sn.ID().String()
+			last.LastTime = sn.Time
+			last.Snapshots++
+			continue
+		}
+		last = &FileVersion{
+			SnapshotID:     sn.ID().String(),
+			ShortID:        sn.ID().Str(),
+			Time:           sn.Time,
+			LastSnapshotID: sn.ID().String(),
+			LastTime:       sn.Time,
+			Snapshots:      1,
+			Type:           node.Type,
+			Size:           node.Size,
+			Mode:           node.Mode,
+			ModTime:        node.ModTime,
+			ContentHash:    hash,
+		}
+		versions = append(versions, last)
+	}
+
+	res := make([]interface{}, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		res = append(res, *versions[i])
+	}
+	return res, nil
+}
+
+// snapshotCoversPath 判断快照的备份路径是否可能包含 filePath
+func snapshotCoversPath(sn *restic.Snapshot, filePath string) bool {
+	for _, p := range sn.Paths {
+		p = path.Clean("/" + filepath.ToSlash(p))
+		if p == "/" || p == filePath || strings.HasPrefix(filePath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeContentHash 根据数据块id计算内容摘要，目录使用子树id，链接使用链接目标
+func nodeContentHash(node *restic.Node) string {
+	h := sha256.New()
+	switch node.Type {
+	case "dir":
+		if node.Subtree != nil {
+			h.Write(node.Subtree[:])
+		}
+	case "symlink":
+		h.Write([]byte(node.LinkTarget))
+	default:
+		for _, id := range node.Content {
+			h.Write(id[:])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -1,6 +1,7 @@
 package resticProxy
 
 import (
+	"github.com/kubackup/kubackup/internal/consts"
 	"github.com/kubackup/kubackup/internal/model"
 	"github.com/kubackup/kubackup/internal/server"
 	"github.com/kubackup/kubackup/internal/service/v1/common"
@@ -9,17 +10,27 @@ import (
 	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/ui/restore"
 	"github.com/kubackup/kubackup/pkg/utils"
 	"math"
+	"sync"
 	"time"
 )
 
+// restoreProgressSaveInterval 还原进度保存间隔
+const restoreProgressSaveInterval = 30 * time.Second
+
 type restorePrinter struct {
 	task          wsTaskInfo.WsTaskInfo
 	weightCount   float64 //数量进度权重
 	weightSize    float64 //大小进度权重
 	lastUpdate    time.Time
+	lastSave      time.Time //上次保存进度的时间
 	errors        []model.ErrorUpdate
 	filesTotal    uint64
 	allBytesTotal uint64
+	restoreLogs   []model.RestoreUpdate
+	logLock       sync.Mutex
+	overwritten   uint //覆盖的已有文件数
+	skipped       uint //跳过的已有文件数
+	deleted       uint //删除的多余文件数
 }
 
 func NewRestorePrinter(t wsTaskInfo.WsTaskInfo) *restorePrinter {
@@ -29,6 +40,7 @@ func NewRestorePrinter(t wsTaskInfo.WsTaskInfo) *restorePrinter {
 		weightSize:    1,
 		filesTotal:    0,
 		allBytesTotal: 0,
+		restoreLogs:   make([]model.RestoreUpdate, 0),
 	}
 }
 
@@ -70,6 +82,11 @@ func (r *restorePrinter) Update(filesFinished, filesTotal, allBytesWritten, allB
 	r.task.(*task.TaskInfo).Progress = &status
 	task.TaskInfos.Set(r.task.GetId(), r.task)
 	r.task.SendMsg(&status)
+	// 定期保存进度，服务中断后可查看已完成的文件数
+	if time.Since(r.lastSave) >= restoreProgressSaveInterval {
+		r.lastSave = time.Now()
+		_ = taskHistoryService.UpdateField(r.task.GetId(), "Progress", &status, common.DBOptions{})
+	}
 }
 
 func (r *restorePrinter) Finish(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) {
@@ -87,14 +104,22 @@ func (r *restorePrinter) Finish(filesFinished, filesTotal, allBytesWritten, allB
 		return
 	}
 
+	r.logLock.Lock()
 	summaryOut := &model.SummaryOutput{
 		MessageType:         "summary",
 		FilesNew:            uint(filesFinished),
+		FilesChanged:        r.overwritten,
+		FilesUnmodified:     r.skipped,
+		FilesDeleted:        r.deleted,
 		DataAdded:           utils.FormatBytes(allBytesWritten),
 		TotalFilesProcessed: uint(filesTotal),
 		TotalBytesProcessed: utils.FormatBytes(allBytesTotal),
 		TotalDuration:       p.SecondsElapsed,
 	}
+	if len(r.restoreLogs) > 0 {
+		_ = taskHistoryService.UpdateField(r.task.GetId(), "RestoreLogs", r.restoreLogs, opt)
+	}
+	r.logLock.Unlock()
 	r.task.SendMsg(summaryOut)
 	err1 := taskHistoryService.UpdateField(r.task.GetId(), "Summary", summaryOut, opt)
 	if err1 != nil {
@@ -111,10 +136,39 @@ func (r *restorePrinter) Finish(filesFinished, filesTotal, allBytesWritten, allB
 	if taskhis.ScannerError != nil || taskhis.RestoreError != nil {
 		status = task.StatusError
 	}
+	if r.task.(*task.TaskInfo).IsCancelled() {
+		status = task.StatusCancel
+	}
 	_ = taskHistoryService.UpdateField(r.task.GetId(), "Status", status, common.DBOptions{})
 	task.TaskInfos.Close(r.task.GetId(), "process end", 1)
 }
 
+// restoreLog 记录对已有文件的处理，任务结束时保存
+func (r *restorePrinter) restoreLog(action, item, reason string) {
+	r.logLock.Lock()
+	defer r.logLock.Unlock()
+	switch action {
+	case RestoreActionOverwrite:
+		r.overwritten++
+	case RestoreActionSkip:
+		r.skipped++
+	case RestoreActionDelete:
+		r.deleted++
+	}
+	if len(r.restoreLogs) >= maxRestoreLogs {
+		return
+	}
+	restoreUpdate := model.RestoreUpdate{
+		MessageType: "restore",
+		Action:      action,
+		Item:        item,
+		Reason:      reason,
+		Time:        time.Now().Format(consts.Custom),
+	}
+	r.task.SendMsg(&restoreUpdate)
+	r.restoreLogs = append(r.restoreLogs, restoreUpdate)
+}
+
 func (r *restorePrinter) SetWeight(weightCount, weightSize float64) {
 	r.weightSize = weightSize
 	r.weightCount = weightCount
@@ -0,0 +1,196 @@
+package resticProxy
+
+import (
+	"context"
+	"github.com/kubackup/kubackup/internal/service/v1/common"
+	wsTaskInfo "github.com/kubackup/kubackup/internal/store/ws_task_info"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"sync"
+)
+
+// DefaultQueueConcurrency 每个存储库默认同时运行的备份数量
+const DefaultQueueConcurrency = 2
+
+// QueueOptions 存储库队列排队参数
+type QueueOptions struct {
+	Priority  int    //优先级，数值越大越先执行，相同优先级先进先出
+	Exclusive bool   //互斥操作，需等待存储库上所有任务结束，执行期间其他任务排队
+	Key       string //相同 key 的任务不会同时执行，如备份路径
+	OnQueued  func() //需要排队时回调
+}
+
+type queueItem struct {
+	opts    QueueOptions
+	seq     uint64
+	ready   chan struct{}
+	granted bool
+}
+
+// repoQueue 单个存储库的任务队列，互斥任务无法执行时后续任务均等待，避免互斥操作一直得不到执行
+type repoQueue struct {
+	mu        sync.Mutex
+	limit     int
+	running   int
+	exclusive bool
+	keys      map[string]bool
+	waiting   []*queueItem
+	seq       uint64
+}
+
+var repoQueues = struct {
+	sync.Mutex
+	queues map[int]*repoQueue
+}{queues: make(map[int]*repoQueue)}
+
+func getRepoQueue(repoid int) *repoQueue {
+	repoQueues.Lock()
+	defer repoQueues.Unlock()
+	q, ok := repoQueues.queues[repoid]
+	if !ok {
+		q = &repoQueue{
+			limit: DefaultQueueConcurrency,
+			keys:  make(map[string]bool),
+		}
+		repoQueues.queues[repoid] = q
+	}
+	return q
+}
+
+// WaitRepoQueue 在存储库队列中等待执行，ctx 取消时退出排队。
+// 返回的 release 需在任务结束后调用
+func WaitRepoQueue(ctx context.Context, repoid int, opts QueueOptions) (func(), error) {
+	limit := DefaultQueueConcurrency
+	rep, err := repositoryService.Get(repoid, common.DBOptions{})
+	if err == nil && rep.MaxConcurrency > 0 {
+		limit = rep.MaxConcurrency
+	}
+	q := getRepoQueue(repoid)
+	q.mu.Lock()
+	q.limit = limit
+	q.seq++
+	item := &queueItem{
+		opts:  opts,
+		seq:   q.seq,
+		ready: make(chan struct{}),
+	}
+	q.push(item)
+	q.schedule()
+	granted := item.granted
+	q.mu.Unlock()
+
+	if !granted && opts.OnQueued != nil {
+		opts.OnQueued()
+	}
+	release := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if item.opts.Exclusive {
+			q.exclusive = false
+		} else {
+			q.running--
+		}
+		if item.opts.Key != "" {
+			delete(q.keys, item.opts.Key)
+		}
+		q.schedule()
+	}
+	select {
+	case <-item.ready:
+		return release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		if item.granted {
+			q.mu.Unlock()
+			release()
+			return nil, ctx.Err()
+		}
+		for i, w := range q.waiting {
+			if w == item {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+		q.schedule()
+		q.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// RepoQueueBusy 存储库上是否有正在执行或排队的任务
+func RepoQueueBusy(repoid int) bool {
+	q := getRepoQueue(repoid)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running > 0 || q.exclusive || len(q.waiting) > 0
+}
+
+// push 按优先级插入队列，相同优先级排在后面
+func (q *repoQueue) push(item *queueItem) {
+	i := len(q.waiting)
+	for j, w := range q.waiting {
+		if w.opts.Priority < item.opts.Priority {
+			i = j
+			break
+		}
+	}
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[i+1:], q.waiting[i:])
+	q.waiting[i] = item
+}
+
+// schedule 按顺序启动可以执行的任务，调用方需持有锁。
+// 相同 key 的任务正在执行时跳过该任务继续查找，互斥任务或并发已满时停止，保证优先级及互斥操作不被插队
+func (q *repoQueue) schedule() {
+	for i := 0; i < len(q.waiting) && !q.exclusive; {
+		item := q.waiting[i]
+		if item.opts.Exclusive {
+			if q.running > 0 {
+				return
+			}
+			q.exclusive = true
+		} else {
+			if q.running >= q.limit {
+				return
+			}
+			if item.opts.Key != "" && q.keys[item.opts.Key] {
+				i++
+				continue
+			}
+			q.running++
+		}
+		if item.opts.Key != "" {
+			q.keys[item.opts.Key] = true
+		}
+		item.granted = true
+		close(item.ready)
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+	}
+}
+
+// lockRepoExclusiveQueued 等待存储库上的任务结束后获取互斥锁，解锁及退出队列在 clean 中执行
+func lockRepoExclusiveQueued(ctx context.Context, repoid int, repo *repository.Repository, clean *CleanCtx, spr *wsTaskInfo.Sprintf) error {
+	return lockRepoQueued(ctx, repoid, repo, true, clean, spr)
+}
+
+// lockRepoQueued 在存储库队列中排队后获取锁，exclusive 为 false 时可与备份等任务同时执行
+func lockRepoQueued(ctx context.Context, repoid int, repo *repository.Repository, exclusive bool, clean *CleanCtx, spr *wsTaskInfo.Sprintf) error {
+	release, err := WaitRepoQueue(ctx, repoid, QueueOptions{
+		Exclusive: exclusive,
+		OnQueued: func() {
+			spr.Append(wsTaskInfo.Info, "waiting for running tasks on the repository\n")
+		},
+	})
+	if err != nil {
+		return err
+	}
+	lock, err := lockRepository(ctx, repo, exclusive)
+	if err != nil {
+		release()
+		return err
+	}
+	clean.AddCleanCtx(func() {
+		unlockRepo(lock)
+		release()
+	})
+	return nil
+}
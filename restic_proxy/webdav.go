@@ -0,0 +1,367 @@
+package resticProxy
+
+import (
+	"context"
+	"fmt"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/repository"
+	"github.com/kubackup/kubackup/pkg/restic_source/rinternal/restic"
+	"golang.org/x/net/webdav"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 快照目录名称格式，避免使用 Windows 不支持的冒号
+const webdavSnapshotTimeFormat = "2006-01-02_15-04-05"
+
+// 快照列表缓存时间
+const webdavSnapshotTTL = time.Minute
+
+// 缓存的树数量
+const webdavTreeCacheSize = 4096
+
+// NewWebdavHandler 只读 WebDAV 服务，目录结构为 /<存储库>/<快照时间>/<路径>
+func NewWebdavHandler(prefix string) http.Handler {
+	trees, _ := lru.New[restic.ID, *restic.Tree](webdavTreeCacheSize)
+	return &webdav.Handler{
+		Prefix: prefix,
+		FileSystem: &snapshotFS{
+			trees:     trees,
+			snapshots: make(map[int]*davSnapshots),
+		},
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+// snapshotFS 基于快照树的只读文件系统
+type snapshotFS struct {
+	trees     *lru.Cache[restic.ID, *restic.Tree]
+	lock      sync.Mutex
+	snapshots map[int]*davSnapshots
+}
+
+// davSnapshots 存储库的快照目录
+type davSnapshots struct {
+	repo   *repository.Repository
+	loaded time.Time
+	names  []string
+	byName map[string]*restic.Snapshot
+}
+
+// davEntry 路径对应的目录或文件
+type davEntry struct {
+	davFileInfo
+	level int //0 根目录，1 存储库，2 快照，3 快照内的文件或目录
+	repo  Repository
+	node  *restic.Node //快照内的文件
+	tree  *restic.ID   //快照或快照内目录对应的树
+}
+
+func (fs *snapshotFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (fs *snapshotFS) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (fs *snapshotFS) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (fs *snapshotFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	e, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return e.davFileInfo, nil
+}
+
+func (fs *snapshotFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	e, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f := &davFile{fs: fs, ctx: ctx, entry: e}
+	if e.node != nil {
+		f.reader, err = newBlobFileReader(ctx, e.repo.repo, e.node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// resolve 逐级查找路径，快照内的目录从缓存的树中查找
+func (fs *snapshotFS) resolve(ctx context.Context, name string) (*davEntry, error) {
+	parts := splitDumpPath(name)
+	e := &davEntry{davFileInfo: davFileInfo{name: "/", mode: os.ModeDir | 0555}}
+	if len(parts) == 0 {
+		return e, nil
+	}
+	repo, ok := webdavRepositories()[parts[0]]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	e = &davEntry{davFileInfo: davFileInfo{name: parts[0], mode: os.ModeDir | 0555}, level: 1, repo: repo}
+	if len(parts) == 1 {
+		return e, nil
+	}
+	sns, err := fs.loadSnapshots(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	sn, ok := sns.byName[parts[1]]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	e = &davEntry{
+		davFileInfo: davFileInfo{name: parts[1], mode: os.ModeDir | 0555, modTime: sn.Time},
+		level:       2,
+		repo:        repo,
+		tree:        sn.Tree,
+	}
+	for _, p := range parts[2:] {
+		if e.tree == nil {
+			return nil, os.ErrNotExist
+		}
+		tree, err := fs.loadTree(ctx, repo.repo, *e.tree)
+		if err != nil {
+			return nil, err
+		}
+		var node *restic.Node
+		for _, n := range tree.Nodes {
+			if n.Name == p {
+				node = n
+				break
+			}
+		}
+		if node == nil || (node.Type != "dir" && node.Type != "file") {
+			return nil, os.ErrNotExist
+		}
+		e = &davEntry{davFileInfo: nodeFileInfo(node), level: 3, repo: repo}
+		if node.Type == "dir" {
+			e.tree = node.Subtree
+		} else {
+			e.node = node
+		}
+	}
+	return e, nil
+}
+
+// readdir 列出目录内容，快照内仅展示文件和目录
+func (fs *snapshotFS) readdir(ctx context.Context, e *davEntry) ([]os.FileInfo, error) {
+	res := make([]os.FileInfo, 0)
+	switch e.level {
+	case 0:
+		for name := range webdavRepositories() {
+			res = append(res, davFileInfo{name: name, mode: os.ModeDir | 0555})
+		}
+		sort.Slice(res, func(i, j int) bool {
+			return res[i].Name() < res[j].Name()
+		})
+	case 1:
+		sns, err := fs.loadSnapshots(ctx, e.repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range sns.names {
+			res = append(res, davFileInfo{name: name, mode: os.ModeDir | 0555, modTime: sns.byName[name].Time})
+		}
+	default:
+		if e.tree == nil {
+			return res, nil
+		}
+		tree, err := fs.loadTree(ctx, e.repo.repo, *e.tree)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range tree.Nodes {
+			if node.Type != "dir" && node.Type != "file" {
+				continue
+			}
+			res = append(res, nodeFileInfo(node))
+		}
+	}
+	return res, nil
+}
+
+func (fs *snapshotFS) loadTree(ctx context.Context, repo *repository.Repository, id restic.ID) (*restic.Tree, error) {
+	if tree, ok := fs.trees.Get(id); ok {
+		return tree, nil
+	}
+	tree, err := restic.LoadTree(ctx, repo, id)
+	if err != nil {
+		return nil, fmt.Errorf("loading tree %v: %v", id.Str(), err)
+	}
+	fs.trees.Add(id, tree)
+	return tree, nil
+}
+
+// loadSnapshots 读取存储库快照并按时间命名，同一时间的快照追加短id区分
+func (fs *snapshotFS) loadSnapshots(ctx context.Context, repo Repository) (*davSnapshots, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	sns, ok := fs.snapshots[repo.repoId]
+	if ok && sns.repo == repo.repo && time.Since(sns.loaded) < webdavSnapshotTTL {
+		return sns, nil
+	}
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, repo.repo.Backend(), repo.repo, &restic.SnapshotFilter{}, []string{}) {
+		snapshots = append(snapshots, sn)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	sort.Sort(snapshots)
+	count := make(map[string]int)
+	for _, sn := range snapshots {
+		count[sn.Time.Local().Format(webdavSnapshotTimeFormat)]++
+	}
+	sns = &davSnapshots{
+		repo:   repo.repo,
+		loaded: time.Now(),
+		names:  make([]string, 0, len(snapshots)),
+		byName: make(map[string]*restic.Snapshot),
+	}
+	for _, sn := range snapshots {
+		name := sn.Time.Local().Format(webdavSnapshotTimeFormat)
+		if count[name] > 1 {
+			name += "_" + sn.ID().Str()
+		}
+		sns.names = append(sns.names, name)
+		sns.byName[name] = sn
+	}
+	fs.snapshots[repo.repoId] = sns
+	return sns, nil
+}
+
+// webdavRepositories 已加载的存储库，名称中的 / 替换为 _，重名时追加存储库id
+func webdavRepositories() map[string]Repository {
+	Myrepositorys.lock.Lock()
+	reps := make([]Repository, 0, len(Myrepositorys.rep))
+	for _, rep := range Myrepositorys.rep {
+		if rep.repo != nil {
+			reps = append(reps, rep)
+		}
+	}
+	Myrepositorys.lock.Unlock()
+
+	count := make(map[string]int)
+	for _, rep := range reps {
+		count[webdavRepoName(rep)]++
+	}
+	res := make(map[string]Repository, len(reps))
+	for _, rep := range reps {
+		name := webdavRepoName(rep)
+		if count[name] > 1 {
+			name += "_" + strconv.Itoa(rep.repoId)
+		}
+		res[name] = rep
+	}
+	return res
+}
+
+func webdavRepoName(rep Repository) string {
+	name := strings.ReplaceAll(rep.repoName, "/", "_")
+	if name == "" {
+		name = strconv.Itoa(rep.repoId)
+	}
+	return name
+}
+
+func nodeFileInfo(node *restic.Node) davFileInfo {
+	fi := davFileInfo{name: node.Name, modTime: node.ModTime}
+	if node.Type == "dir" {
+		fi.mode = os.ModeDir | node.Mode.Perm()
+	} else {
+		fi.mode = node.Mode.Perm()
+		fi.size = int64(node.Size)
+	}
+	return fi
+}
+
+type davFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi davFileInfo) Name() string       { return fi.name }
+func (fi davFileInfo) Size() int64        { return fi.size }
+func (fi davFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi davFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi davFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi davFileInfo) Sys() interface{}   { return nil }
+
+// davFile 只读文件，目录内容在首次 Readdir 时加载
+type davFile struct {
+	fs       *snapshotFS
+	ctx      context.Context
+	entry    *davEntry
+	reader   io.ReadSeeker
+	children []os.FileInfo
+	pos      int
+}
+
+func (f *davFile) Close() error {
+	return nil
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, os.ErrInvalid
+	}
+	return f.reader.Read(p)
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if f.reader == nil {
+		return 0, os.ErrInvalid
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.entry.IsDir() {
+		return nil, os.ErrInvalid
+	}
+	if f.children == nil {
+		children, err := f.fs.readdir(f.ctx, f.entry)
+		if err != nil {
+			return nil, err
+		}
+		f.children = children
+	}
+	rest := f.children[f.pos:]
+	if count <= 0 {
+		f.pos = len(f.children)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.pos += count
+	return rest[:count], nil
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	return f.entry.davFileInfo, nil
+}